@@ -0,0 +1,238 @@
+// generates a JSON Schema of the websocket protocol from the message types in pkg/protocol so clients in other
+// languages can be generated from it, run with go generate ./pkg/protocol
+package main
+
+import (
+	"chatapp/pkg/protocol"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// string types whose values are listed in the schema
+var enums = map[reflect.Type][]string{
+	reflect.TypeFor[protocol.Status]():    {string(protocol.Online), string(protocol.Away), string(protocol.DoNotDisturb), string(protocol.Offline)},
+	reflect.TypeFor[protocol.ErrorCode](): nil, // filled in from the constants in the source
+}
+
+func main() {
+	out := flag.String("o", "protocol.schema.json", "file to write the schema to")
+	dir := flag.String("dir", ".", "directory of the protocol package, for doc comments and error codes")
+	flag.Parse()
+
+	docs, err := loadDocs(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := &generator{docs: docs, defs: make(map[string]any)}
+
+	messageTypes := make([]string, 0, len(protocol.PayloadTypes))
+	for messageType := range protocol.PayloadTypes {
+		messageTypes = append(messageTypes, string(messageType))
+	}
+	slices.Sort(messageTypes)
+	messages := make([]any, 0, len(messageTypes))
+	for _, messageType := range messageTypes {
+		messages = append(messages, map[string]any{
+			"title":       messageType,
+			"description": docs.constants[messageType],
+			"type":        "object",
+			"properties": map[string]any{
+				"type":    map[string]any{"const": messageType},
+				"id":      map[string]any{"type": "string", "description": "optional client supplied correlation ID, echoed back in error frames"},
+				"payload": g.schema(protocol.PayloadTypes[protocol.MessageType(messageType)]),
+			},
+			"required": []string{"type"},
+		})
+	}
+	schema := map[string]any{
+		"$schema":            "https://json-schema.org/draft/2020-12/schema",
+		"title":              "Chat websocket protocol",
+		"description":        "A WebSocketMessage sent over /ws. Text frames are JSON, binary frames with the " + protocol.MsgpackSubprotocol + " subprotocol are MessagePack with the same fields.",
+		"x-protocol-version": protocol.ProtocolVersion,
+		"oneOf":              messages,
+		"$defs":              g.defs,
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Wrote %d message types to %s", len(messages), *out)
+}
+
+type generator struct {
+	docs *docs
+	defs map[string]any // Key: struct name, Value: its schema
+}
+
+// the schema of a Go type as encoding/json encodes it, structs are added to defs and referenced
+func (g *generator) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		schema := map[string]any{"type": "string"}
+		if values, ok := enums[t]; ok {
+			schema["enum"] = values
+		}
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder so recursive types terminate
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	log.Fatalf("Unsupported type %s", t)
+	return nil
+}
+
+// the schema of a struct from its json tags, fields without omitempty are required
+func (g *generator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := g.schema(field.Type)
+		if description := g.docs.fields[t.Name()+"."+field.Name]; description != "" {
+			property = withDescription(property, description)
+		}
+		properties[name] = property
+		if !slices.Contains(strings.Split(options, ","), "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties, "required": required}
+	if description := g.docs.types[t.Name()]; description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+// adds a description next to a $ref, which can't have siblings in older drafts so it is wrapped
+func withDescription(schema map[string]any, description string) map[string]any {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]any{"allOf": []any{schema}, "description": description}
+	}
+	schema["description"] = description
+	return schema
+}
+
+// comments in the protocol package source
+type docs struct {
+	types     map[string]string // Key: type name, Value: its doc comment
+	fields    map[string]string // Key: type name.field name, Value: its line or doc comment
+	constants map[string]string // Key: constant value, Value: its line comment
+}
+
+// reads the doc comments of the protocol package, the values of its ErrorCode constants go into enums
+// fails if there are none, e.g. when run from another directory, rather than writing a schema without them
+func loadDocs(dir string) (*docs, error) {
+	fset := token.NewFileSet()
+	filter := func(info os.FileInfo) bool { return !strings.HasSuffix(info.Name(), "_test.go") }
+	packages, err := parser.ParseDir(fset, dir, filter, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	d := &docs{types: make(map[string]string), fields: make(map[string]string), constants: make(map[string]string)}
+	var errorCodes []string
+	for _, pkg := range packages {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						doc := spec.Doc
+						if doc == nil {
+							doc = gen.Doc
+						}
+						d.types[spec.Name.Name] = text(doc)
+						if structType, ok := spec.Type.(*ast.StructType); ok {
+							for _, field := range structType.Fields.List {
+								for _, name := range field.Names {
+									d.fields[spec.Name.Name+"."+name.Name] = text(field.Comment, field.Doc)
+								}
+							}
+						}
+					case *ast.ValueSpec:
+						ident, ok := spec.Type.(*ast.Ident)
+						if !ok || len(spec.Values) != 1 {
+							continue
+						}
+						literal, ok := spec.Values[0].(*ast.BasicLit)
+						if !ok || literal.Kind != token.STRING {
+							continue
+						}
+						value, _ := strconv.Unquote(literal.Value)
+						switch ident.Name {
+						case "MessageType":
+							d.constants[value] = text(spec.Comment)
+						case "ErrorCode":
+							errorCodes = append(errorCodes, value)
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(d.types) == 0 || len(errorCodes) == 0 {
+		return nil, fmt.Errorf("No doc comments or ErrorCode constants found in %s, run with go generate ./pkg/protocol or set -dir.", dir)
+	}
+	slices.Sort(errorCodes)
+	enums[reflect.TypeFor[protocol.ErrorCode]()] = errorCodes
+	return d, nil
+}
+
+// the text of the first non-empty comment group, on one line
+func text(groups ...*ast.CommentGroup) string {
+	for _, group := range groups {
+		if s := strings.Join(strings.Fields(group.Text()), " "); s != "" {
+			return s
+		}
+	}
+	return ""
+}
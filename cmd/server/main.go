@@ -1,20 +1,61 @@
 package main
 
 import (
+	"chatapp/internal/broker"
+	"chatapp/internal/chat"
 	"chatapp/internal/config"
 	"chatapp/internal/postgres"
 	"chatapp/internal/router"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 
 	config.Load()
 	postgres.Init()
-	router := router.NewRouter()
+	b := broker.New()
+	hub := chat.NewHub(b, config.App.Chat.HubShards)
+	go hub.Run() // have hub running on its own thread
 
-	log.Printf("Listening on port %v...", config.App.Port)
-	http.ListenAndServe(fmt.Sprintf(":%v", config.App.Port), router)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", config.App.Port),
+		Handler: router.NewRouter(hub),
+	}
+	go func() {
+		log.Printf("Listening on port %v...", config.App.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for docker stop or ctrl+c
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down, waiting up to %v for connections to drain...", config.App.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.App.ShutdownTimeout)
+	defer cancel()
+	// stop accepting connections first, websocket connections are hijacked so the hub closes those itself
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	if err := hub.Shutdown(ctx); err != nil {
+		log.Printf("Connections didn't drain in time: %v", err)
+	}
+	// publishes anything still queued for other instances
+	if err := b.Close(); err != nil {
+		log.Printf("Error closing broker: %v", err)
+	}
+	if err := postgres.DB.Close(); err != nil {
+		log.Printf("Error closing DB: %v", err)
+	}
+	log.Println("Server stopped")
 }
@@ -6,22 +6,22 @@ export async function fetchWithAuth(endpoint, init) {
     return res;
   }
   console.log("Access token failed. Trying to get a new one...");
-  // if access token expired with 401 error, call refresh with refresh token
+  await refreshAccessToken();
+  // try the original request again after refreshing access token
+  return fetch(endpoint, init);
+}
+
+// gets a new access token with the refresh token, redirects to login if the session expired
+export async function refreshAccessToken() {
   const refreshRes = await fetch('/auth/refresh', {
     method: "POST",
     credentials: "include",
   });
-
-  if (refreshRes.ok) {
-    console.log(
-      "Successfully called refresh endpoint. should make request now."
-    );
-    // try the original request again after refreshing access token
-    return fetch(endpoint, init);
-  } else {
+  if (!refreshRes.ok) {
     window.location.href = "/login"; // if failed to make request to refresh access token, then redirect to login
     throw new Error("Session expired. Redirecting to login.");
   }
+  console.log("Successfully called refresh endpoint. should make request now.");
 }
 
 // GET JSON - the current authenticated users id and username
@@ -39,6 +39,70 @@ export async function getUserInfo() {
   return data;
 }
 
+// GET JSON - the public rooms that can be joined from the lobby
+export async function listRooms() {
+  const res = await fetchWithAuth(`${SERVER_BASE_URL}/rooms`, {
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const errorText = await res.text();
+    throw new Error(
+      `Failed to fetch rooms (${res.status} ${res.statusText}): ${errorText}`
+    );
+  }
+  return res.json();
+}
+
+// GET JSON - the number of unread messages in every room from listRooms
+export async function getUnreadCounts() {
+  const res = await fetchWithAuth(`${SERVER_BASE_URL}/rooms/unread`, {
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const errorText = await res.text();
+    throw new Error(
+      `Failed to fetch unread counts (${res.status} ${res.statusText}): ${errorText}`
+    );
+  }
+  return res.json();
+}
+
+// POST token - join a room with an invite link token, returns the room
+export async function acceptInviteLink(token) {
+  const res = await fetchWithAuth(`${SERVER_BASE_URL}/invites/link`, {
+    method: "POST",
+    credentials: "include",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token: token }),
+  });
+  if (!res.ok) {
+    const errorText = await res.text();
+    throw new Error(
+      `Failed to accept invite (${res.status} ${res.statusText}): ${errorText}`
+    );
+  }
+  return res.json();
+}
+
+// GET JSON - a page of stored room messages older than the before message ID
+export async function getRoomMessages(roomID, before) {
+  const params = new URLSearchParams();
+  if (before) {
+    params.set("before", before);
+  }
+  const res = await fetchWithAuth(
+    `${SERVER_BASE_URL}/rooms/${encodeURIComponent(roomID)}/messages?${params}`,
+    { credentials: "include" }
+  );
+  if (!res.ok) {
+    const errorText = await res.text();
+    throw new Error(
+      `Failed to fetch messages (${res.status} ${res.statusText}): ${errorText}`
+    );
+  }
+  return res.json();
+}
+
 // POST username - update the current users username in db
 export async function updateUsername(newUsername) {
   const res = await fetchWithAuth(`${SERVER_BASE_URL}/auth/update-username`, {
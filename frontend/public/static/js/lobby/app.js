@@ -1,19 +1,32 @@
-import { getUserInfo } from "../api.js";
-import { renderUsername } from "./ui.js";
+import { acceptInviteLink } from "../api.js";
 import { initWebSocketConn } from "./websocket.js";
 import "./events.js";
 
 async function init() {
   try {
-    const data = await getUserInfo();
-    window.id = data.id;
-    window.username = data.username;
     window.users = [];
-    renderUsername(data.username);
-    initWebSocketConn("1");
+    initWebSocketConn(await getInitialRoomID()); // the welcome frame tells us who we are
   } catch (err) {
     console.error(err);
   }
 }
 
 init();
+
+// join the room from an invite link if the lobby was opened with one, otherwise the default room
+async function getInitialRoomID() {
+  const params = new URLSearchParams(window.location.search);
+  const token = params.get("invite");
+  if (!token) {
+    return "1";
+  }
+  window.history.replaceState(null, "", window.location.pathname); // don't reuse the token on reload
+  try {
+    const room = await acceptInviteLink(token);
+    return room.id;
+  } catch (err) {
+    console.error(err);
+    alert("This invite link is invalid or has expired.");
+    return "1";
+  }
+}
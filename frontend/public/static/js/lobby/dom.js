@@ -5,11 +5,13 @@ export const usernameDisplay = document.getElementById("username");
 export const chatMessages = document.getElementById("chatMessages");
 export const messageInput = document.getElementById("messageInput"); // chat input
 export const charCount = document.getElementById("charCount"); // chat input character count
+export const typingIndicator = document.getElementById("typingIndicator"); // who else is typing
 
 // buttons
 export const sendBtn = document.getElementById("sendBtn");
 export const logoutBtn = document.getElementById("logoutBtn");
 export const darkModeToggle = document.getElementById("darkModeToggle");
+export const statusSelect = document.getElementById("statusSelect");
 
 // edit username modal
 export const editUsernameBtn = document.getElementById("editUsernameBtn");
@@ -25,3 +27,4 @@ export const joinRoomModal = document.getElementById("joinRoomModal");
 export const confirmJoinRoomBtn = document.getElementById("confirmJoinRoomBtn");
 export const cancelJoinRoomBtn = document.getElementById("cancelJoinRoomBtn");
 export const roomInput = document.getElementById("roomInput");
+export const roomList = document.getElementById("roomList");
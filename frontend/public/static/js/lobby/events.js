@@ -1,5 +1,12 @@
-import { updateUsername, logout } from "../api.js";
 import {
+  updateUsername,
+  logout,
+  getRoomMessages,
+  listRooms,
+  getUnreadCounts,
+} from "../api.js";
+import {
+  chatMessages,
   messageInput,
   sendBtn,
   logoutBtn,
@@ -9,6 +16,7 @@ import {
   cancelUsernameBtn,
   saveUsernameBtn,
   darkModeToggle,
+  statusSelect,
   joinRoomBtn,
   roomInput,
   confirmJoinRoomBtn,
@@ -21,39 +29,123 @@ import {
   renderUsername,
   loadDarkModePref,
   clearChatMessages,
+  prependChatMessages,
+  getOldestMessageID,
+  renderRoomList,
 } from "./ui.js";
 import {
-  initWebSocketConn,
+  switchRoom,
   sendChatMessage,
   sendUsernameUpdateMessage,
+  sendReaction,
+  sendTypingStart,
+  sendTypingStop,
+  markRoomRead,
+  sendStatus,
 } from "./websocket.js";
 
 // -------------------------------------- SEND MESSAGE ----------------------------------
+let replyTo = null; // message ID the next message replies to in a thread
+const defaultPlaceholder = messageInput.placeholder;
+
 // send chat messages with enter
 messageInput.addEventListener("keydown", (event) => {
   if (event.key === "Enter" && !event.shiftKey) {
     event.preventDefault();
     const text = messageInput.value.trim();
     if (text) {
-      sendChatMessage(text);
+      sendChatMessage(text, replyTo);
+      sendTypingStop();
       messageInput.value = "";
+      setReplyTo(null);
       renderCharCount();
     }
+  } else if (event.key === "Escape" && replyTo) {
+    setReplyTo(null);
   }
 });
 // send chat messages with click
 sendBtn.addEventListener("click", () => {
   const text = messageInput.value.trim();
   if (text !== "") {
-    sendChatMessage(text);
+    sendChatMessage(text, replyTo);
+    sendTypingStop();
     messageInput.value = "";
+    setReplyTo(null);
   }
 });
 
-// handles ui resizing
+// replies to a message in its thread, or goes back to sending to the room when null
+function setReplyTo(messageID) {
+  replyTo = messageID;
+  messageInput.placeholder = messageID
+    ? "Reply in thread (Esc to cancel)"
+    : defaultPlaceholder;
+}
+
+// handles ui resizing and tells the room when we are typing
 messageInput.addEventListener("input", () => {
   renderCharCount();
   resizeTextarea();
+  if (messageInput.value.trim()) {
+    sendTypingStart();
+  } else {
+    sendTypingStop();
+  }
+});
+
+// -------------------------------------- SCROLL BACK HISTORY ----------------------------------
+let loadingHistory = false;
+let oldestLoaded = null; // cursor that returned the last page of a room
+
+
+// load older messages when scrolled to the top of the chat
+chatMessages.addEventListener("scroll", async () => {
+  const before = getOldestMessageID();
+  if (
+    chatMessages.scrollTop > 0 ||
+    loadingHistory ||
+    !before ||
+    before === oldestLoaded
+  ) {
+    return;
+  }
+  loadingHistory = true;
+  try {
+    const page = await getRoomMessages(window.roomID, before);
+    prependChatMessages(page.messages);
+    if (!page.next_before) {
+      oldestLoaded = getOldestMessageID();
+    }
+  } catch (err) {
+    console.error(err);
+  }
+  loadingHistory = false;
+});
+
+// -------------------------------------- REACTIONS AND THREADS ----------------------------------
+// reply to a message in a thread
+chatMessages.addEventListener("click", (event) => {
+  const button = event.target.closest(".reply-button");
+  if (button) {
+    setReplyTo(button.closest("[data-message-id]").dataset.messageId);
+    messageInput.focus();
+  }
+});
+
+// toggle a reaction by clicking it, or add a new one with the + button
+chatMessages.addEventListener("click", (event) => {
+  const button = event.target.closest(".reaction, .add-reaction");
+  if (!button) {
+    return;
+  }
+  const messageID = button.closest("[data-message-id]").dataset.messageId;
+  const emoji = button.classList.contains("add-reaction")
+    ? prompt("React with an emoji:")?.trim()
+    : button.dataset.emoji;
+  if (emoji) {
+    sendReaction(messageID, emoji);
+  }
 });
 
 // -------------------------------------- EDIT USERNAME MODAL ----------------------------------
@@ -101,8 +193,25 @@ usernameInput.addEventListener("keydown", function (event) {
 });
 
 // -------------------------------------- JOIN ROOM MODAL ----------------------------------
-joinRoomBtn.addEventListener("click", () => {
+// switch the websocket connection over to another room
+function joinRoom(roomID) {
+  switchRoom(roomID);
+  clearChatMessages();
+  joinRoomModal.classList.add("hidden");
+  roomInput.value = "";
+}
+
+joinRoomBtn.addEventListener("click", async () => {
   joinRoomModal.classList.remove("hidden");
+  try {
+    const [rooms, unreadCounts] = await Promise.all([
+      listRooms(),
+      getUnreadCounts(),
+    ]);
+    renderRoomList(rooms, joinRoom, unreadCounts);
+  } catch (err) {
+    console.error(err);
+  }
 });
 
 cancelJoinRoomBtn.addEventListener("click", () => {
@@ -113,11 +222,10 @@ cancelJoinRoomBtn.addEventListener("click", () => {
 confirmJoinRoomBtn.addEventListener("click", () => {
   const newRoomID = roomInput.value.trim();
   if (newRoomID) {
-    initWebSocketConn(newRoomID);
-    clearChatMessages();
+    joinRoom(newRoomID);
+    return;
   }
   joinRoomModal.classList.add("hidden");
-  roomInput.value = "";
 });
 // -------------------------------------- LOGOUT ----------------------------------
 logoutBtn.addEventListener("click", async () => {
@@ -131,6 +239,13 @@ logoutBtn.addEventListener("click", async () => {
   window.location.href = "/";
 });
 
+// -------------------------------------- READ RECEIPTS ----------------------------------
+// messages that arrived while the tab was hidden are read once it is shown again
+document.addEventListener("visibilitychange", markRoomRead);
+
+// -------------------------------------- STATUS ----------------------------------
+statusSelect.addEventListener("change", () => sendStatus(statusSelect.value));
+
 // -------------------------------------- DARKMODE ----------------------------------
 // toggle and save darkmode
 darkModeToggle.addEventListener("change", function () {
@@ -4,6 +4,8 @@ import {
   messageInput,
   usernameDisplay,
   darkModeToggle,
+  roomList,
+  typingIndicator,
 } from "./dom.js";
 
 // -------------------------------------- CHAT MESSAGE DISPLAY ----------------------------------
@@ -20,6 +22,26 @@ export function renderChatMessage(payload) {
   chatMessages.scrollTop = chatMessages.scrollHeight; // scroll to bottom
 }
 
+// render a page of older messages above the current ones, keeping the scroll position in place
+export function prependChatMessages(messages) {
+  const previousHeight = chatMessages.scrollHeight;
+  const fragment = document.createDocumentFragment();
+  messages.forEach((payload) => fragment.append(createChatMessage(payload)));
+  chatMessages.prepend(fragment);
+  chatMessages.scrollTop += chatMessages.scrollHeight - previousHeight;
+}
+
+// the ID of the oldest rendered message, used as the cursor for loading older history
+export function getOldestMessageID() {
+  return chatMessages.querySelector("[data-message-id]")?.dataset.messageId;
+}
+
+// the ID of the newest rendered message, used to mark the room read
+export function getNewestMessageID() {
+  const messages = chatMessages.querySelectorAll("[data-message-id]");
+  return messages[messages.length - 1]?.dataset.messageId;
+}
+
 // clear previous messages
 export function clearChatMessages() {
   chatMessages.textContent = "";
@@ -28,6 +50,9 @@ export function clearChatMessages() {
 // creates HTML element for chat message
 function createChatMessage(payload) {
   const messageDiv = document.createElement("div");
+  if (payload.message_id) {
+    messageDiv.dataset.messageId = payload.message_id;
+  }
   const timestampSpan = document.createElement("span");
   timestampSpan.classList.add("timestamp");
   const date = new Date(payload.time);
@@ -46,12 +71,150 @@ function createChatMessage(payload) {
     const usernameStrong = document.createElement("strong");
     usernameStrong.style.color = getUserColour(payload.sender_username);
     usernameStrong.textContent = payload.sender_username;
-    const textNode = document.createTextNode(`: ${payload.text} `);
-    messageDiv.append(usernameStrong, textNode, timestampSpan);
+    if (payload.receiver_id) {
+      // direct messages show who they were sent to
+      messageDiv.classList.add("direct-message");
+      usernameStrong.textContent += ` → ${payload.receiver_username}`;
+    }
+    if (payload.parent_message_id) {
+      messageDiv.classList.add("thread-reply");
+      usernameStrong.textContent = `↪ ${usernameStrong.textContent}`;
+    }
+    const textSpan = document.createElement("span");
+    textSpan.classList.add("message-text");
+    messageDiv.append(usernameStrong, ": ", textSpan, " ", timestampSpan);
+    setMessageText(messageDiv, payload.text, payload.edited_at, payload.deleted);
+    // only stored room messages can be reacted to and replied to
+    if (payload.message_id && payload.room_id && !payload.deleted) {
+      const reactionsDiv = document.createElement("div");
+      reactionsDiv.classList.add("reactions");
+      messageDiv.append(reactionsDiv);
+      renderReactions(reactionsDiv, payload.reactions || []);
+      if (!payload.parent_message_id) {
+        messageDiv.append(createThreadControls(payload.reply_count));
+      }
+    }
   }
   return messageDiv;
 }
 
+// -------------------------------------- THREADS ----------------------------------
+// update the reply count of a thread parent after a new reply
+export function updateThread(thread) {
+  const replyCount = findChatMessage(thread.parent_message_id)?.querySelector(
+    ".reply-count",
+  );
+  if (replyCount) {
+    setReplyCount(replyCount, thread.reply_count);
+  }
+}
+
+// the reply count of a message and a button to reply to it in a thread
+function createThreadControls(count) {
+  const threadDiv = document.createElement("div");
+  threadDiv.classList.add("thread");
+  const replyCount = document.createElement("span");
+  replyCount.classList.add("reply-count");
+  setReplyCount(replyCount, count);
+  const replyButton = document.createElement("button");
+  replyButton.classList.add("reply-button");
+  replyButton.textContent = "Reply";
+  threadDiv.append(replyCount, replyButton);
+  return threadDiv;
+}
+
+function setReplyCount(replyCount, count) {
+  replyCount.textContent = !count ? "" : count === 1 ? "1 reply" : `${count} replies`;
+}
+
+// -------------------------------------- REACTIONS ----------------------------------
+// update the reactions under a message after someone toggled one
+export function updateReactions(update) {
+  const reactionsDiv = findChatMessage(update.message_id)?.querySelector(
+    ".reactions",
+  );
+  if (!reactionsDiv) {
+    return;
+  }
+  // updates don't say which reactions are ours, so keep what we knew and apply our own change
+  const reacted = new Set(
+    [...reactionsDiv.querySelectorAll(".reaction.reacted")].map(
+      (button) => button.dataset.emoji,
+    ),
+  );
+  if (update.user_id === window.id) {
+    update.added ? reacted.add(update.emoji) : reacted.delete(update.emoji);
+  }
+  renderReactions(
+    reactionsDiv,
+    update.reactions.map((reaction) => ({
+      ...reaction,
+      reacted: reacted.has(reaction.emoji),
+    })),
+  );
+}
+
+// renders a button per emoji with its count, and a button to add a new reaction
+function renderReactions(reactionsDiv, reactions) {
+  reactionsDiv.textContent = "";
+  reactions.forEach((reaction) => {
+    const button = document.createElement("button");
+    button.classList.add("reaction");
+    button.classList.toggle("reacted", Boolean(reaction.reacted));
+    button.dataset.emoji = reaction.emoji;
+    button.textContent = `${reaction.emoji} ${reaction.count}`;
+    reactionsDiv.append(button);
+  });
+  const addButton = document.createElement("button");
+  addButton.classList.add("add-reaction");
+  addButton.title = "Add reaction";
+  addButton.textContent = "+";
+  reactionsDiv.append(addButton);
+}
+
+// update a rendered message after it was edited or deleted
+export function updateChatMessage(messageID, text, editedAt, deleted) {
+  const messageDiv = findChatMessage(messageID);
+  if (messageDiv) {
+    setMessageText(messageDiv, text, editedAt, deleted);
+    if (deleted) {
+      messageDiv.querySelector(".reactions")?.remove();
+    }
+  }
+}
+
+// the rendered element of a stored message
+function findChatMessage(messageID) {
+  return chatMessages.querySelector(
+    `[data-message-id="${CSS.escape(messageID)}"]`,
+  );
+}
+
+// sets the text of a chat message, marking edited and deleted messages
+function setMessageText(messageDiv, text, editedAt, deleted) {
+  const textSpan = messageDiv.querySelector(".message-text");
+  messageDiv.classList.toggle("deleted-message", Boolean(deleted));
+  if (deleted) {
+    textSpan.textContent = "message deleted";
+  } else {
+    textSpan.textContent = editedAt ? `${text} (edited)` : text;
+  }
+}
+
+// -------------------------------------- TYPING INDICATOR ----------------------------------
+// show who else in the room is typing
+export function renderTypingUsers(usernames) {
+  if (usernames.length === 0) {
+    typingIndicator.textContent = "";
+  } else if (usernames.length === 1) {
+    typingIndicator.textContent = `${usernames[0]} is typing...`;
+  } else if (usernames.length <= 3) {
+    typingIndicator.textContent = `${usernames.join(", ")} are typing...`;
+  } else {
+    typingIndicator.textContent = "Several people are typing...";
+  }
+}
+
 // -------------------------------------- USER LIST ----------------------------------
 // update the user list with currently active users
 export function renderActiveUsers(users) {
@@ -62,6 +225,9 @@ export function renderActiveUsers(users) {
     const td = document.createElement("td");
     const dot = document.createElement("span");
     dot.classList.add("online-dot");
+    if (user.status && user.status !== "online") {
+      dot.classList.add(user.status); // away or dnd
+    }
     td.appendChild(dot);
     td.append(` ${user.username}`);
     tr.appendChild(td);
@@ -69,11 +235,37 @@ export function renderActiveUsers(users) {
   });
 }
 
+// -------------------------------------- ROOM LIST ----------------------------------
+// render the rooms in the join room modal, calls onSelect with the room ID when one is clicked
+export function renderRoomList(rooms, onSelect, unreadCounts = []) {
+  roomList.textContent = "";
+  const unread = new Map(unreadCounts.map((c) => [c.room_id, c.unread]));
+  rooms.forEach((room) => {
+    const li = document.createElement("li");
+    li.textContent = room.topic ? `${room.name} — ${room.topic}` : room.name;
+    if (unread.get(room.id)) {
+      const badge = document.createElement("span");
+      badge.classList.add("unread-badge");
+      badge.textContent = unread.get(room.id) > 99 ? "99+" : unread.get(room.id);
+      li.appendChild(badge);
+    }
+    li.addEventListener("click", () => onSelect(room.id));
+    roomList.appendChild(li);
+  });
+}
+
 // -------------------------------------- CHAT MESSAGE INPUT ----------------------------------
-const MAX_CHARS = 500;
-// updates character limit
+let maxChars = 500; // replaced by the servers limit from the welcome frame
+// updates character limit, the server counts characters rather than UTF-16 code units
 export function renderCharCount() {
-  charCount.textContent = `${messageInput.value.length} / ${MAX_CHARS}`;
+  const length = [...messageInput.value].length;
+  charCount.textContent = `${length} / ${maxChars}`;
+  charCount.classList.toggle("over-limit", length > maxChars);
+}
+// applies the message text limit the server advertised
+export function setMaxChars(limit) {
+  maxChars = limit;
+  renderCharCount();
 }
 // resizes input box
 export function resizeTextarea() {
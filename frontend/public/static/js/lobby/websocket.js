@@ -1,20 +1,68 @@
 import { HUB_BASE_URL } from "../config.js";
+import { refreshAccessToken } from "../api.js";
+import { statusSelect } from "./dom.js";
 import {
   renderRoomHeader,
   renderChatMessage,
   renderActiveUsers,
+  updateChatMessage,
+  updateReactions,
+  updateThread,
+  renderTypingUsers,
+  getNewestMessageID,
+  clearChatMessages,
+  setMaxChars,
+  renderUsername,
 } from "./ui.js";
 
 let socket = null;
+const typingUsers = new Map(); // user ID to username of everyone else typing in the room
+let typingSentAt = 0; // when typing_start was last sent, it is repeated while typing so it doesn't time out
+
+let readTimer = null; // pending read_up_to, batched so a burst of messages is only marked read once
+
+// close code the server sends when it is restarting, and the code for a connection that dropped without a close
+// frame, we reconnect after a random delay so clients don't all reconnect at once
+const CLOSE_SERVICE_RESTART = 1012;
+const CLOSE_ABNORMAL = 1006;
+const CLOSE_SLOW_CONSUMER = 4008; // we fell behind on messages, e.g. the tab was throttled
+
+// protocol version of the server this lobby was written against, and the optional frames it shows
+const PROTOCOL_VERSION = 1;
+const FEATURES = ["typing", "presence", "reactions", "read_receipts"];
+const RECONNECT_MIN_MS = 3000;
+const RECONNECT_JITTER_MS = 5000;
+
+// typing_start is sent at most this often, the server stops showing us as typing after 5 seconds without one
+const TYPING_REFRESH_MS = 3000;
 
 const MessageType = {
   Chat: "chat",
   UsernameUpdate: "username_update",
   UserList: "userlist",
+  DirectMessage: "direct_message",
+  Error: "error",
+  Ack: "ack",
+  MessageEdit: "message_edit",
+  MessageDelete: "message_delete",
+  React: "react",
+  ReactionUpdate: "reaction_update",
+  TypingStart: "typing_start",
+  TypingStop: "typing_stop",
+  Typing: "typing",
+  ReadUpTo: "read_up_to",
+  ReadReceipt: "read_receipt",
+  Presence: "presence",
+  JoinRoom: "join_room",
+  LeaveRoom: "leave_room",
+  Hello: "hello",
+  Welcome: "welcome",
+  Resume: "resume",
 };
 
 // initializes connection with server hub
-export function initWebSocketConn(roomID) {
+// lastSeenMessageID resumes a dropped connection, the server only sends the messages sent since
+export function initWebSocketConn(roomID, lastSeenMessageID) {
   if (
     socket &&
     (socket.readyState === WebSocket.OPEN ||
@@ -23,14 +71,47 @@ export function initWebSocketConn(roomID) {
     socket.close(1000); // 1000 for normal close
   }
 
-  socket = new WebSocket(`/ws?room_id=${roomID}`);
-  renderRoomHeader(roomID);
+  resetRoomState(roomID);
+  let url = `/ws?room_id=${encodeURIComponent(roomID)}`;
+  if (lastSeenMessageID) {
+    url += `&last_seen_message_id=${encodeURIComponent(lastSeenMessageID)}`;
+  }
+  socket = new WebSocket(url);
+  let opened = false; // stays false if the upgrade was refused, e.g. because our access token expired
   // upgrader.Upgrade() in Go server will trigger this, once updating protocol from HTTP1.1 to WebSocket
   socket.addEventListener("open", () => {
     console.log("WebSocket connected");
+    opened = true;
+    sendMessage(
+      JSON.stringify({
+        type: MessageType.Hello,
+        payload: {
+          protocol_version: PROTOCOL_VERSION,
+          features: FEATURES,
+          client: "lobby",
+        },
+      }),
+    );
+    // a chosen status is forgotten once all of our connections close, e.g. when switching rooms
+    if (statusSelect.value !== "online") {
+      sendStatus(statusSelect.value);
+    }
   });
   // triggered on clean and abnormal closes
-  socket.onclose = (e) => console.warn("WebSocket closed", e);
+  const thisSocket = socket;
+  socket.onclose = (e) => {
+    console.warn("WebSocket closed", e);
+    if (e.reason) {
+      renderNotice(e.reason); // e.g. kicked or banned by a moderator
+    }
+    if (
+      e.code === CLOSE_SERVICE_RESTART ||
+      e.code === CLOSE_ABNORMAL ||
+      e.code === CLOSE_SLOW_CONSUMER
+    ) {
+      reconnect(thisSocket, !opened);
+    }
+  };
   // connection failed to establish, transmission error, or CORS/TLS issue
   socket.onerror = (e) => console.error("WebSocket error", e);
 
@@ -38,26 +119,162 @@ export function initWebSocketConn(roomID) {
   socket.addEventListener("message", (event) => {
     const data = JSON.parse(event.data);
     console.log("Received from server: ", data);
+    // frames from a room we just left can still arrive after switching
+    if (data.payload?.room_id && data.payload.room_id !== window.roomID) {
+      return;
+    }
     switch (data.type) {
+      case MessageType.Welcome:
+        window.id = data.payload.user_id;
+        window.username = data.payload.username;
+        renderUsername(data.payload.username);
+        setMaxChars(data.payload.limits.max_text_runes);
+        break;
+      case MessageType.Resume:
+        if (!data.payload.complete) {
+          clearChatMessages(); // missed too much, the recent history follows instead
+        }
+        break;
       case MessageType.Chat:
+        renderChatMessage(data.payload);
+        if (data.payload.thread) {
+          updateThread(data.payload.thread);
+        }
+        markRoomRead();
+        break;
+      case MessageType.DirectMessage:
         renderChatMessage(data.payload);
         break;
       case MessageType.UserList:
         window.users = data.payload.users;
         renderActiveUsers(data.payload.users);
         break;
+      case MessageType.Presence:
+        window.users.forEach((user) => {
+          if (user.id === data.payload.user_id) {
+            user.status = data.payload.status;
+          }
+        });
+        renderActiveUsers(window.users);
+        break;
+      case MessageType.Typing:
+        if (data.payload.typing) {
+          typingUsers.set(data.payload.user_id, data.payload.username);
+        } else {
+          typingUsers.delete(data.payload.user_id);
+        }
+        renderTypingUsers([...typingUsers.values()]);
+        break;
+      case MessageType.MessageEdit:
+        updateChatMessage(
+          data.payload.message_id,
+          data.payload.text,
+          data.payload.time,
+          false,
+        );
+        break;
+      case MessageType.MessageDelete:
+        updateChatMessage(data.payload.message_id, "", null, true);
+        break;
+      case MessageType.ReactionUpdate:
+        updateReactions(data.payload);
+        break;
+      case MessageType.JoinRoom:
+        break; // the rooms history follows
+      case MessageType.LeaveRoom:
+        if (data.payload.reason) {
+          renderNotice(data.payload.reason); // removed by a moderator
+        }
+        break;
+      case MessageType.ReadReceipt:
+        break; // other users read positions aren't shown in the lobby yet
+      case MessageType.Ack:
+        break; // the message is also broadcast back to the room, nothing to render
+      case MessageType.Error:
+        console.warn("Server rejected message: ", data.payload);
+        renderNotice(data.payload.message);
+        break;
       default:
         console.warn("WebSocket message type not supported: ", data.type);
     }
   });
 }
 
+// reconnects to the current room once the server is back, unless we already switched to a new connection
+// the server replays what we missed after the newest message we have
+// refused is set when the connection never opened, most likely because our access token expired
+function reconnect(closedSocket, refused) {
+  const delay = RECONNECT_MIN_MS + Math.random() * RECONNECT_JITTER_MS;
+  setTimeout(async () => {
+    if (refused) {
+      await refreshAccessToken(); // redirects to login if the session is over
+    }
+    if (socket === closedSocket && window.roomID) {
+      const lastSeenMessageID = getNewestMessageID();
+      if (!lastSeenMessageID) {
+        clearChatMessages(); // nothing to resume from, the room history is sent again
+      }
+      initWebSocketConn(window.roomID, lastSeenMessageID);
+    }
+  }, delay);
+}
+
+// switches to another room, over the open connection when there is one
+export function switchRoom(roomID) {
+  if (!socket || socket.readyState !== WebSocket.OPEN) {
+    initWebSocketConn(roomID);
+    return;
+  }
+  if (window.roomID) {
+    sendTypingStop();
+    sendMessage(
+      JSON.stringify({
+        type: MessageType.LeaveRoom,
+        payload: { room_id: window.roomID },
+      }),
+    );
+  }
+  resetRoomState(roomID);
+  sendMessage(
+    JSON.stringify({
+      type: MessageType.JoinRoom,
+      payload: { room_id: roomID },
+    }),
+  );
+}
+
+// forgets the state of the previous room
+function resetRoomState(roomID) {
+  window.roomID = roomID;
+  window.users = [];
+  typingUsers.clear();
+  typingSentAt = 0;
+  clearTimeout(readTimer);
+  readTimer = null;
+  renderTypingUsers([]);
+  renderActiveUsers([]);
+  renderRoomHeader(roomID);
+}
+
+// shows a message from the server in the chat as a notification
+function renderNotice(text) {
+  renderChatMessage({
+    sender_id: "notification",
+    text: text,
+    time: new Date().toISOString(),
+  });
+}
+
 // -------------------------------------- WebSocket Send ----------------------------------
-export function sendChatMessage(text) {
+// parentMessageID is set when replying in a thread
+export function sendChatMessage(text, parentMessageID) {
   let message = JSON.stringify({
     type: MessageType.Chat,
     payload: {
+      room_id: window.roomID,
       text: text,
+      client_msg_id: newClientMsgID(), // lets the server deduplicate resends and acknowledge this message
+      parent_message_id: parentMessageID,
     },
   });
   sendMessage(message);
@@ -72,6 +289,77 @@ export function sendUsernameUpdateMessage(username) {
   });
   sendMessage(message);
 }
+export function sendReaction(messageID, emoji) {
+  let message = JSON.stringify({
+    type: MessageType.React,
+    payload: {
+      room_id: window.roomID,
+      message_id: messageID,
+      emoji: emoji,
+    },
+  });
+  sendMessage(message);
+}
+
+// away and dnd show for all of our clients until set back to online
+export function sendStatus(status) {
+  sendMessage(
+    JSON.stringify({ type: MessageType.Presence, payload: { status: status } }),
+  );
+}
+
+// tells the room we are typing, called on every input but only sent every few seconds
+export function sendTypingStart() {
+  if (Date.now() - typingSentAt < TYPING_REFRESH_MS) {
+    return;
+  }
+  typingSentAt = Date.now();
+  sendMessage(
+    JSON.stringify({
+      type: MessageType.TypingStart,
+      payload: { room_id: window.roomID },
+    }),
+  );
+}
+export function sendTypingStop() {
+  if (typingSentAt === 0) {
+    return;
+  }
+  typingSentAt = 0;
+  sendMessage(
+    JSON.stringify({
+      type: MessageType.TypingStop,
+      payload: { room_id: window.roomID },
+    }),
+  );
+}
+
+// marks the newest message read once the page is visible, called whenever new messages arrive
+export function markRoomRead() {
+  if (readTimer || document.visibilityState !== "visible") {
+    return;
+  }
+  readTimer = setTimeout(() => {
+    readTimer = null;
+    const messageID = getNewestMessageID();
+    if (messageID) {
+      sendMessage(
+        JSON.stringify({
+          type: MessageType.ReadUpTo,
+          payload: { room_id: window.roomID, message_id: messageID },
+        }),
+      );
+    }
+  }, 1000);
+}
+
+// unique ID for an outgoing message, randomUUID is only available in secure contexts
+function newClientMsgID() {
+  if (crypto.randomUUID) {
+    return crypto.randomUUID();
+  }
+  return `${Date.now().toString(36)}-${Math.random().toString(36).slice(2)}`;
+}
 
 function sendMessage(message) {
   if (socket.readyState === WebSocket.OPEN) {
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -12,16 +13,16 @@ func GetClaimFromToken(claimKey, token string, keyFunc func(*jwt.Token) (interfa
 	if err != nil {
 		return "", fmt.Errorf("Failed to extract claim %s from token", claimKey)
 	}
-	return claims[claimKey].(string), nil
+	return stringClaim(claims, claimKey)
 }
 
 // verifies and gets a claim value from raw activation token string
 func GetClaimFromActivationToken(claimKey, token string) (string, error) {
-	claimValue, err := GetClaimFromToken(claimKey, token, KeyFuncActivation)
+	claims, err := parseActivationToken(token)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("Failed to extract claim %s from token", claimKey)
 	}
-	return claimValue, nil
+	return stringClaim(claims, claimKey)
 }
 
 // verifies and gets a claim value from an access cookie
@@ -30,7 +31,7 @@ func GetClaimFromAccessCookie(claimKey string, r *http.Request) (string, error)
 	if err != nil {
 		return "", err
 	}
-	return claims[claimKey].(string), nil
+	return stringClaim(claims, claimKey)
 }
 
 // verifies and gets claim from query parameters
@@ -39,5 +40,28 @@ func GetClaimFromQueryParams(claimKey string, r *http.Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return claims[claimKey].(string), nil
+	return stringClaim(claims, claimKey)
+}
+
+// gets a string claim, tokens missing it or with another type are invalid rather than a panic
+func stringClaim(claims jwt.MapClaims, claimKey string) (string, error) {
+	value, ok := claims[claimKey].(string)
+	if !ok {
+		return "", fmt.Errorf("Token is missing claim %s.", claimKey)
+	}
+	return value, nil
+}
+
+// verifies an invite link token and gets the room and inviter it was created for
+func GetRoomInviteClaims(token string) (roomID string, inviterID string, err error) {
+	claims, err := ParseToken(token, KeyFuncInvite)
+	if err != nil {
+		return "", "", err
+	}
+	roomID, roomOk := claims["room_id"].(string)
+	inviterID, inviterOk := claims["inviter_id"].(string)
+	if claims["purpose"] != roomInvitePurpose || !roomOk || !inviterOk {
+		return "", "", errors.New("Invalid invite token.")
+	}
+	return roomID, inviterID, nil
 }
@@ -17,6 +17,10 @@ func KeyFuncActivation(token *jwt.Token) (interface{}, error) {
 	return config.App.Auth.ActivationTokenKey, nil
 }
 
+func KeyFuncInvite(token *jwt.Token) (interface{}, error) {
+	return config.App.Auth.InviteTokenKey, nil
+}
+
 // Parses, validates JWT token, keyFunc tells Parse() which key it should use
 func ParseToken(token string, keyFunc func(*jwt.Token) (interface{}, error)) (jwt.MapClaims, error) {
 	parsedToken, err := jwt.Parse(token, keyFunc)
@@ -45,5 +49,17 @@ func ParseQueryParams(r *http.Request) (jwt.MapClaims, error) {
 	if token == "" {
 		return nil, errors.New("Missing JWT in query parameters.")
 	}
-	return ParseToken(token, KeyFuncActivation)
+	return parseActivationToken(token)
+}
+
+// parses an activation or password reset token, rejecting tokens signed with the same key for another purpose
+func parseActivationToken(token string) (jwt.MapClaims, error) {
+	claims, err := ParseToken(token, KeyFuncActivation)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["purpose"]; ok {
+		return nil, errors.New("Invalid or expired token.")
+	}
+	return claims, nil
 }
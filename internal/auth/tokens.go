@@ -11,6 +11,9 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// purpose claim that stops activation tokens being used as invite links and vice versa
+const roomInvitePurpose = "room_invite"
+
 // create short term access token
 func CreateAccessToken(id string) (string, error) {
 	claims := jwt.MapClaims{
@@ -43,6 +46,18 @@ func CreateActivationToken(email string) (string, error) {
 	return token.SignedString(config.App.Auth.ActivationTokenKey)
 }
 
+// create invite link token for joining a room, signed with its own key so it can't activate an account or reset a password
+func CreateRoomInviteToken(roomID, inviterID string) (string, error) {
+	claims := jwt.MapClaims{
+		"purpose":    roomInvitePurpose,
+		"room_id":    roomID,
+		"inviter_id": inviterID,
+		"exp":        time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(config.App.Auth.InviteTokenKey)
+}
+
 // creates and returns new access and refresh tokens
 func CreateSessionTokens(id string) (string, string, error) {
 	accessToken, err := CreateAccessToken(id)
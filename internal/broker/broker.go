@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"chatapp/internal/config"
+	"log"
+	"sync"
+)
+
+// fans hub events out to every server instance so rooms, user lists and presence span replicas
+// publishers may or may not receive their own messages, subscribers are expected to ignore them
+type Broker interface {
+	// queues data for every other instance, never blocks on the network
+	Publish(data []byte) error
+	// delivers published data in order, a nil message means messages may have been missed and state should be resynced
+	Messages() <-chan []byte
+	// stops delivery and closes the Messages channel
+	Close() error
+}
+
+// creates the broker chosen by the CHAT_BROKER setting, in memory unless set to postgres
+func New() Broker {
+	switch config.App.Chat.Broker {
+	case "postgres":
+		b, err := NewPostgres(config.App.PG.PgConnString())
+		if err != nil {
+			log.Fatal("Could not start postgres broker: ", err)
+		}
+		log.Println("Using postgres LISTEN/NOTIFY broker")
+		return b
+	case "", "memory":
+		return NewMemory().Connect()
+	default:
+		log.Fatalf("Unknown CHAT_BROKER %q, expected memory or postgres", config.App.Chat.Broker)
+		return nil
+	}
+}
+
+// unbounded fifo so publishing never blocks the hub, drained by its own goroutine
+type queue struct {
+	mu     sync.Mutex
+	items  [][]byte
+	ready  chan struct{} // has a value while items is non empty or the queue is closed
+	closed bool
+}
+
+func newQueue() *queue {
+	return &queue{ready: make(chan struct{}, 1)}
+}
+
+// appends data, returns false if the queue was closed
+func (q *queue) push(data []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, data)
+	q.signal()
+	return true
+}
+
+// stops drain once the remaining items are handed off
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.signal()
+}
+
+func (q *queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// passes every item to send in order until the queue is closed and empty
+func (q *queue) drain(send func([]byte)) {
+	for range q.ready {
+		q.mu.Lock()
+		items, closed := q.items, q.closed
+		q.items = nil
+		q.mu.Unlock()
+		for _, data := range items {
+			send(data)
+		}
+		if closed {
+			return
+		}
+	}
+}
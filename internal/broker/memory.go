@@ -0,0 +1,75 @@
+package broker
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("Broker is closed.")
+
+// in process broker for single node use and tests, every broker connected to the same Memory shares messages
+type Memory struct {
+	mu          sync.Mutex
+	subscribers map[*memoryBroker]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{subscribers: make(map[*memoryBroker]struct{})}
+}
+
+// returns a new broker for one hub
+func (m *Memory) Connect() Broker {
+	b := &memoryBroker{memory: m, queue: newQueue(), messages: make(chan []byte), done: make(chan struct{})}
+	m.mu.Lock()
+	m.subscribers[b] = struct{}{}
+	m.mu.Unlock()
+	go func() {
+		b.queue.drain(func(data []byte) {
+			select {
+			case b.messages <- data:
+			case <-b.done: // dropped, the hub stopped reading
+			}
+		})
+		close(b.messages)
+	}()
+	return b
+}
+
+type memoryBroker struct {
+	memory   *Memory
+	queue    *queue // messages from other brokers waiting for the hub
+	messages chan []byte
+	done     chan struct{}
+}
+
+// queues data for every other broker connected to the same Memory
+func (b *memoryBroker) Publish(data []byte) error {
+	b.memory.mu.Lock()
+	defer b.memory.mu.Unlock()
+	if _, ok := b.memory.subscribers[b]; !ok {
+		return ErrClosed
+	}
+	for subscriber := range b.memory.subscribers {
+		if subscriber != b {
+			subscriber.queue.push(data)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Messages() <-chan []byte {
+	return b.messages
+}
+
+func (b *memoryBroker) Close() error {
+	b.memory.mu.Lock()
+	_, ok := b.memory.subscribers[b]
+	delete(b.memory.subscribers, b)
+	b.memory.mu.Unlock()
+	if !ok {
+		return ErrClosed
+	}
+	close(b.done)
+	b.queue.close()
+	return nil
+}
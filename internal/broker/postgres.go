@@ -0,0 +1,146 @@
+package broker
+
+import (
+	"chatapp/internal/postgres"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// LISTEN/NOTIFY channel shared by every instance
+	postgresChannel = "chat_hub"
+	// NOTIFY payloads must be under 8000 bytes, larger messages are stored in broker_payloads and sent by id
+	maxNotifyPayload = 7900
+	// marks a NOTIFY payload as a broker_payloads id, hub messages are JSON so never start with it
+	payloadRefPrefix = "@"
+	// how often an idle listener connection is checked
+	listenerPingInterval = 90 * time.Second
+)
+
+// broker backed by postgres LISTEN/NOTIFY so every instance connected to the same database shares messages
+// instances receive their own messages back
+type Postgres struct {
+	listener  *pq.Listener
+	outbound  *queue // messages waiting to be sent with NOTIFY
+	messages  chan []byte
+	done      chan struct{}
+	published sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// starts listening on the shared channel with its own connection, publishing uses postgres.DB
+func NewPostgres(connStr string) (*Postgres, error) {
+	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Postgres broker listener: %v", err)
+		}
+	})
+	if err := listener.Listen(postgresChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	p := &Postgres{
+		listener: listener,
+		outbound: newQueue(),
+		messages: make(chan []byte),
+		done:     make(chan struct{}),
+	}
+	p.published.Add(1)
+	go func() {
+		defer p.published.Done()
+		p.outbound.drain(p.notify)
+	}()
+	go p.listen()
+	return p, nil
+}
+
+func (p *Postgres) Publish(data []byte) error {
+	if !p.outbound.push(data) {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (p *Postgres) Messages() <-chan []byte {
+	return p.messages
+}
+
+// sends any queued messages, then stops listening
+func (p *Postgres) Close() error {
+	err := ErrClosed
+	p.closeOnce.Do(func() {
+		p.outbound.close()
+		p.published.Wait()
+		close(p.done)
+		err = p.listener.Close()
+	})
+	return err
+}
+
+// sends one message, storing it in the database first if it is too large for NOTIFY
+func (p *Postgres) notify(data []byte) {
+	payload := string(data)
+	if len(payload) > maxNotifyPayload {
+		id, err := postgres.SaveBrokerPayload(payload)
+		if err != nil {
+			log.Printf("Error storing broker payload: %v", err)
+			return
+		}
+		payload = payloadRefPrefix + id
+	}
+	if err := postgres.Notify(postgresChannel, payload); err != nil {
+		log.Printf("Error publishing to postgres broker: %v", err)
+	}
+}
+
+// delivers notifications to the hub until closed
+// pq sends a nil notification after reconnecting, which is passed on so the hub resyncs
+func (p *Postgres) listen() {
+	defer close(p.messages)
+	for {
+		select {
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			var data []byte
+			if n != nil {
+				var err error
+				if data, err = p.load(n.Extra); err != nil {
+					log.Printf("Error loading broker payload: %v", err)
+					continue
+				}
+			}
+			select {
+			case p.messages <- data:
+			case <-p.done:
+				return
+			}
+		case <-time.After(listenerPingInterval):
+			go func() {
+				if err := p.listener.Ping(); err != nil {
+					log.Printf("Postgres broker ping failed: %v", err)
+				}
+			}()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// returns the message a notification carries, loading it from the database if it was too large to send inline
+func (p *Postgres) load(payload string) ([]byte, error) {
+	id, stored := strings.CutPrefix(payload, payloadRefPrefix)
+	if !stored {
+		return []byte(payload), nil
+	}
+	payload, err := postgres.GetBrokerPayload(id)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(payload), nil
+}
@@ -1,7 +1,12 @@
 package chat
 
 import (
+	"chatapp/pkg/protocol"
+	"fmt"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -9,15 +14,57 @@ import (
 
 // client is a middleman between websocket connection and hub
 type Client struct {
-	ID       string // uuid of the peer
-	Username string // username of peer
-	RoomID   string // room id peer is subscribed to
+	ID        string // uuid of the peer
+	SessionID string // random ID of this connection, sent in the welcome frame
+	RoomID    string // room joined with the room_id query param, used for inbound messages that don't name a room
+
+	// username of peer, changed by the read goroutine and read by every hub shard the client is in
+	username atomic.Pointer[string]
 
 	Hub *Hub // the hub managing this client
 	// the websocket connection.
 	Conn *websocket.Conn
 	// buffered channel of outbound messages
-	Send chan []byte
+	Send chan *Frame
+
+	binary bool // the client asked for the msgpack subprotocol, frames are sent as MessagePack binary messages
+
+	// guards sending on and closing Send, which the hub coordinator, its shards and the read goroutine all do
+	sendMu     sync.Mutex
+	sendClosed bool
+
+	// hashmap of Key:RoomID, Value: newest user list that didn't fit in Send with the coalesce slow consumer policy
+	// guarded by sendMu
+	coalesced map[string]*Frame
+
+	// hashset of optional frame types the client didn't ask for in its hello, nil until it says hello
+	// guarded by sendMu
+	skipped map[protocol.MessageType]struct{}
+
+	// hashset of IDs of direct messages to this user queued on Send, so one loaded from the database and also
+	// delivered live is only sent once, guarded by sendMu
+	directQueued map[string]struct{}
+
+	// close code and reason sent in the close frame once Send is closed, zero sends an empty close frame
+	// only written before closing Send
+	closeCode   int
+	closeReason string
+
+	// unix nanoseconds when the peer last sent a message, read by the hub to mark idle users away
+	lastActive atomic.Int64
+
+	// rate limits on inbound messages by type, and on how often the connection was limited
+	// only used by the read goroutine
+	limits map[protocol.MessageType]*tokenBucket
+	abuse  tokenBucket
+
+	// the client was sent a welcome, only used by the read goroutine
+	welcomed bool
+
+	// hashset of room IDs the client has joined, only changed by the hub
+	// the mutex lets the read goroutine check membership before dispatching to a room
+	joinedMu sync.RWMutex
+	joined   map[string]struct{}
 }
 
 const (
@@ -29,19 +76,41 @@ const (
 	// send pings to peer with this period, make it 90% of pong wait to give second ping a chance incase
 	// first ping was lost or dead
 	pingPeriod = (pongWait * 9) / 10
-	// maximum total message size allowed to receive from peer
-	maxMessageSize = 512
+	// smallest hard limit on message size read from the connection, peers sending more than the read limit are
+	// disconnected, messages over the configured frame size but under it are rejected with an error frame
+	maxReadSize = 64 * 1024
+	// close frame reasons must fit in a 125 byte control frame payload along with the 2 byte code
+	maxCloseReasonSize = 123
+	// smaller frames aren't compressed even if the client negotiated per-message-deflate, it wouldn't save much
+	minCompressSize = 256
 )
 
 func NewClient(id string, username string, roomID string, hub *Hub, conn *websocket.Conn) *Client {
-	return &Client{
-		ID:       id,
-		Username: username,
-		RoomID:   roomID,
-		Hub:      hub,
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
+	c := &Client{
+		ID:        id,
+		SessionID: newSessionID(),
+		RoomID:    roomID,
+		Hub:       hub,
+		Conn:      conn,
+		Send:      make(chan *Frame, 256),
+		joined:    make(map[string]struct{}),
+		limits:    make(map[protocol.MessageType]*tokenBucket),
+
+		coalesced:    make(map[string]*Frame),
+		directQueued: make(map[string]struct{}),
+		binary:       conn != nil && conn.Subprotocol() == protocol.MsgpackSubprotocol,
 	}
+	c.setUsername(username)
+	c.touch()
+	return c
+}
+
+func (c *Client) Username() string {
+	return *c.username.Load()
+}
+
+func (c *Client) setUsername(username string) {
+	c.username.Store(&username)
 }
 
 // transfers messages from websocket connection receive buffer to the hub broadcast channel
@@ -49,9 +118,9 @@ func (c *Client) ReceiveWsMessage() {
 	defer func() { // unregister from hub and close connection when client no longer reading
 		c.Hub.UnregisterClient(c)
 		c.Conn.Close()
-		log.Printf("Closed connection with %s in Room %s", c.Username, c.RoomID)
+		log.Printf("Closed connection with %s", c.Username())
 	}()
-	c.Conn.SetReadLimit(maxMessageSize)              // max message size for all frames combined
+	c.Conn.SetReadLimit(readLimit())                 // max message size for all frames combined
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait)) // ReadMessage() will error if called after deadline
 	// only a pong message can reset the pong timeout
 	c.Conn.SetPongHandler(func(string) error { // pong handler is a callback function that gets called when pong frame is received
@@ -61,13 +130,32 @@ func (c *Client) ReceiveWsMessage() {
 	for {
 		// call SetReadDeadline explicitly, which is done by the Pong Handler to manage heartbeat
 		// do not enforce readmessage with a deadline to read normal messages, only for pong, because some peers might only listen
-		_, message, err := c.Conn.ReadMessage() // read all frames of a message into []byte
+		messageType, message, err := c.Conn.ReadMessage() // read all frames of a message into []byte
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("websocket error: %v", err)
 			}
 			break
 		}
+		if len(message) > maxFrameSize() {
+			correlationID := peekCorrelationID(message)
+			if allowMessage(c, "", correlationID) {
+				welcomeWithoutHello(c)
+				sendError(c, correlationID, protocol.CodeMessageTooLarge, fmt.Sprintf("Messages can be at most %d bytes.", maxFrameSize()))
+			}
+			continue
+		}
+		// binary messages are MessagePack, dispatched as JSON like text messages
+		if messageType == websocket.BinaryMessage {
+			if message, err = msgpackToJSON(message); err != nil {
+				log.Println(err)
+				if allowMessage(c, "", "") {
+					sendError(c, "", protocol.CodeMalformed, "Binary messages must be a MessagePack map with a type and payload.")
+				}
+				continue
+			}
+		}
+		c.touch()
 		// push message into hub broadcast channel buffer
 		dispatch(c, message)
 	}
@@ -87,16 +175,20 @@ func (c *Client) SendWsMessage() {
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait)) // must set write deadline, otherwise none responsive client may hang
 			if !ok {
 				// hub closed this clients send channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{}) // this triggers the frontend JS socket.OnClose()
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage()) // this triggers the frontend JS socket.OnClose()
 				return
 			}
-			w, err := c.Conn.NextWriter(websocket.TextMessage) // create a websocket writer
-			if err != nil {
+			if err := c.writeFrame(message); err != nil {
 				return
 			}
-			w.Write(message)
-			if err := w.Close(); err != nil {
-				return
+			// user lists kept aside while Send was full are only newer than what was queued before them once it drained
+			if len(c.Send) == 0 {
+				for _, frame := range c.takeCoalesced() {
+					c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+					if err := c.writeFrame(frame); err != nil {
+						return
+					}
+				}
 			}
 		case <-ticker.C: // signal sent to ticker.C every ping period
 			// reset write deadline every ping
@@ -107,3 +199,139 @@ func (c *Client) SendWsMessage() {
 		}
 	}
 }
+
+// writes a frame in the encoding the client asked for
+func (c *Client) writeFrame(frame *Frame) error {
+	messageType, data := websocket.TextMessage, frame.JSON
+	if c.binary {
+		var err error
+		if data, err = frame.Msgpack(); err != nil {
+			log.Println(err)
+			return nil // skip the frame rather than disconnecting
+		}
+		messageType = websocket.BinaryMessage
+	}
+	c.Conn.EnableWriteCompression(len(data) >= minCompressSize) // does nothing unless compression was negotiated
+	if err := c.Conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	if frame.receiverID == c.ID {
+		frame.delivered.Do(func() { c.Hub.persist(func() { markDelivered(frame.directMessageID) }) })
+	}
+	return nil
+}
+
+// returns true if the client has joined the room
+func (c *Client) InRoom(roomID string) bool {
+	c.joinedMu.RLock()
+	defer c.joinedMu.RUnlock()
+	_, ok := c.joined[roomID]
+	return ok
+}
+
+// the room an inbound message is for, the clients initial room if the message didn't name one
+// returns false if the client hasn't joined that room
+func (c *Client) roomFor(roomID string) (string, bool) {
+	if roomID == "" {
+		roomID = c.RoomID
+	}
+	return roomID, roomID != "" && c.InRoom(roomID)
+}
+
+// the rooms the client has joined
+func (c *Client) joinedRooms() []string {
+	c.joinedMu.RLock()
+	defer c.joinedMu.RUnlock()
+	roomIDs := make([]string, 0, len(c.joined))
+	for roomID := range c.joined {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs
+}
+
+// adds a room to the clients joined rooms unless Send was closed, only called by the rooms hub shard
+// checked under sendMu so a disconnecting client can't be added back to a room after the hub saw its rooms
+func (c *Client) join(roomID string) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return false
+	}
+	c.joinedMu.Lock()
+	defer c.joinedMu.Unlock()
+	c.joined[roomID] = struct{}{}
+	return true
+}
+
+// removes a room from the clients joined rooms, only called by the rooms hub shard
+func (c *Client) leave(roomID string) {
+	c.joinedMu.Lock()
+	defer c.joinedMu.Unlock()
+	delete(c.joined, roomID)
+}
+
+// queues data on Send without blocking, returns false if Send is closed or the client was disconnected instead
+// a full buffer is handled by the slow consumer policy so a slow client can't hold up the hub, a disconnected
+// client's write goroutine closes the connection and its read goroutine then unregisters it, which removes it from
+// its rooms like any other disconnect
+func (c *Client) trySend(frame *Frame) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return false
+	}
+	if c.skips(frame) {
+		return true
+	}
+	if frame.receiverID == c.ID {
+		if _, ok := c.directQueued[frame.directMessageID]; ok {
+			return true
+		}
+		c.directQueued[frame.directMessageID] = struct{}{}
+	}
+	select {
+	case c.Send <- frame:
+		return true
+	default:
+		return c.sendFull(frame)
+	}
+}
+
+// closes Send, telling the peer why in the close frame, returns false if Send was already closed
+func (c *Client) closeSend(code int, reason string) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return false
+	}
+	c.setCloseReason(code, reason)
+	c.sendClosed = true
+	close(c.Send)
+	return true
+}
+
+// records that the peer is active
+func (c *Client) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *Client) lastActiveTime() time.Time {
+	return time.Unix(0, c.lastActive.Load())
+}
+
+// sets the close frame sent once Send is closed
+func (c *Client) setCloseReason(code int, reason string) {
+	if len(reason) > maxCloseReasonSize {
+		reason = strings.ToValidUTF8(reason[:maxCloseReasonSize], "") // don't leave half a character at the cut
+	}
+	c.closeCode = code
+	c.closeReason = reason
+}
+
+// the close frame payload to send, empty if the hub didn't give a reason
+func (c *Client) closeMessage() []byte {
+	if c.closeCode == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
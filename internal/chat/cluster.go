@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"chatapp/pkg/protocol"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// instances that haven't published anything for this long are treated as gone, they heartbeat every presence sweep
+const instanceTimeout = 3 * presenceSweepInterval
+
+type brokerEventKind string
+
+const (
+	roomEvent      brokerEventKind = "room"      // Data for every client in RoomID
+	userEvent      brokerEventKind = "user"      // Data for every client of UserID, MessageID is marked delivered once written
+	membersEvent   brokerEventKind = "members"   // Users are the publishing instances clients in RoomID
+	presenceEvent  brokerEventKind = "presence"  // Status of UserID from the publishing instances clients, who are in Rooms
+	statusEvent    brokerEventKind = "status"    // Status was chosen by UserID on the publishing instance
+	kickEvent      brokerEventKind = "kick"      // removes UserID from RoomID with Reason
+	syncEvent      brokerEventKind = "sync"      // publisher started or missed messages, every instance republishes its state
+	heartbeatEvent brokerEventKind = "heartbeat" // keeps an idle instance from timing out
+
+	// never published, tells shards to forget an instance that timed out
+	instanceGoneEvent brokerEventKind = "instance_gone"
+)
+
+// hub state shared with the other server instances through the broker
+type brokerEvent struct {
+	Kind      brokerEventKind     `json:"kind"`
+	Instance  string              `json:"instance"` // ID of the publishing hub
+	RoomID    string              `json:"room_id,omitempty"`
+	UserID    string              `json:"user_id,omitempty"`
+	MessageID string              `json:"message_id,omitempty"`
+	Reason    string              `json:"reason,omitempty"`
+	Status    protocol.Status     `json:"status,omitempty"`
+	Rooms     []string            `json:"rooms,omitempty"`
+	Users     []protocol.UserItem `json:"users,omitempty"`
+	Data      json.RawMessage     `json:"data,omitempty"` // encoded WebSocketMessage to send to local clients
+}
+
+// random ID telling this hubs broker events apart from other instances
+func newInstanceID() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatal("Could not generate hub instance ID: ", err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// sends an event to the other instances
+func (h *Hub) publish(event brokerEvent) {
+	event.Instance = h.instance
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := h.broker.Publish(data); err != nil {
+		log.Printf("Error publishing %s event: %v", event.Kind, err)
+	}
+}
+
+// handler for an event from the broker, applies other instances changes to this hubs clients
+// room events are passed on to the rooms shard
+func (h *Hub) handleBrokerMessage(data []byte) {
+	if data == nil { // the broker reconnected and may have missed events
+		log.Println("Broker reconnected, resyncing hub state")
+		h.publish(brokerEvent{Kind: syncEvent})
+		return
+	}
+	var event brokerEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("Invalid broker event: %v", err)
+		return
+	}
+	if event.Instance == h.instance {
+		return
+	}
+	h.instances[event.Instance] = time.Now()
+
+	switch event.Kind {
+	case roomEvent, membersEvent, kickEvent:
+		h.shardFor(event.RoomID).events <- event
+	case userEvent:
+		frame := NewFrame(event.Data)
+		if event.MessageID != "" {
+			frame.deliverTo(event.UserID, event.MessageID)
+		}
+		h.sendToUser(event.UserID, frame)
+	case presenceEvent:
+		h.setRemotePresence(event.Instance, event.UserID, event.Status, event.Rooms)
+	case statusEvent:
+		if p := h.presence[event.UserID]; p != nil && len(h.users[event.UserID]) > 0 {
+			h.chooseStatus(event.UserID, p, event.Status)
+		}
+	case syncEvent:
+		h.publishState()
+		for _, shard := range h.shards {
+			shard.events <- event
+		}
+	case heartbeatEvent:
+	default:
+		log.Printf("Unsupported broker event %q from instance %s", event.Kind, event.Instance)
+	}
+}
+
+// handler for a room event from another instance or the hub goroutine
+func (s *roomShard) handleEvent(event brokerEvent) {
+	switch event.Kind {
+	case roomEvent:
+		if s.rooms[event.RoomID] != nil {
+			s.broadcastData(event.RoomID, NewFrame(event.Data))
+		}
+	case membersEvent:
+		s.setRemoteMembers(event.Instance, event.RoomID, event.Users)
+	case kickEvent:
+		s.kickLocal(KickRequest{event.RoomID, event.UserID, event.Reason})
+	case syncEvent:
+		for roomID := range s.rooms {
+			s.publishMembers(roomID)
+		}
+	case instanceGoneEvent:
+		for roomID, instances := range s.remoteRooms {
+			if _, ok := instances[event.Instance]; ok {
+				s.setRemoteMembers(event.Instance, roomID, nil)
+			}
+		}
+	}
+}
+
+// republishes this hubs user presence for an instance that started or missed events, shards republish their rooms
+func (h *Hub) publishState() {
+	for userID, p := range h.presence {
+		if p.local != protocol.Offline {
+			h.publish(brokerEvent{Kind: presenceEvent, UserID: userID, Status: p.local, Rooms: h.userRooms(userID)})
+		}
+	}
+}
+
+// tells the other instances which of this hubs clients are in a room, an empty list once none are left
+func (s *roomShard) publishMembers(roomID string) {
+	s.hub.publish(brokerEvent{Kind: membersEvent, RoomID: roomID, Users: s.localUsers(roomID)})
+}
+
+// stores another instances clients in a room and sends the combined user list to this hubs clients there
+func (s *roomShard) setRemoteMembers(instance, roomID string, users []protocol.UserItem) {
+	if len(users) == 0 {
+		delete(s.remoteRooms[roomID], instance)
+		if len(s.remoteRooms[roomID]) == 0 {
+			delete(s.remoteRooms, roomID)
+		}
+	} else {
+		if s.remoteRooms[roomID] == nil {
+			s.remoteRooms[roomID] = make(map[string][]protocol.UserItem)
+		}
+		s.remoteRooms[roomID][instance] = users
+	}
+	if s.rooms[roomID] != nil {
+		s.broadcastActiveUserList(roomID)
+	}
+}
+
+// clients of other instances in a room
+func (s *roomShard) remoteUsers(roomID string) []protocol.UserItem {
+	var users []protocol.UserItem
+	for _, instanceUsers := range s.remoteRooms[roomID] {
+		users = append(users, instanceUsers...)
+	}
+	return users
+}
+
+// publishes a heartbeat and forgets instances that stopped publishing, e.g. after crashing
+func (h *Hub) sweepInstances(now time.Time) {
+	h.publish(brokerEvent{Kind: heartbeatEvent})
+	for instance, seen := range h.instances {
+		if now.Sub(seen) > instanceTimeout {
+			log.Printf("Hub instance %s timed out", instance)
+			delete(h.instances, instance)
+			h.dropInstance(instance)
+		}
+	}
+}
+
+// removes a gone instances clients from user lists and presence
+func (h *Hub) dropInstance(instance string) {
+	for _, shard := range h.shards {
+		shard.events <- brokerEvent{Kind: instanceGoneEvent, Instance: instance}
+	}
+	for userID, p := range h.presence {
+		if _, ok := p.remote[instance]; ok {
+			delete(p.remote, instance)
+			h.showPresence(userID, p)
+		}
+	}
+}
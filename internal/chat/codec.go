@@ -1,8 +1,13 @@
 package chat
 
 import (
+	"bytes"
+	"chatapp/pkg/protocol"
 	"encoding/json"
 	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func Decode[T any](data []byte) (*T, error) {
@@ -20,3 +25,102 @@ func Encode(v any) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// an outbound WebSocketMessage encoded once and shared by every client it is sent to
+type Frame struct {
+	Type protocol.MessageType
+	JSON []byte // also what is published to other instances
+
+	// MessagePack encoding, only made the first time the frame is sent to a binary client
+	once    sync.Once
+	msgpack []byte
+	err     error
+
+	// set on direct messages, marked delivered the first time the frame is written to one of the receivers connections
+	receiverID      string
+	directMessageID string
+	delivered       sync.Once
+}
+
+// marks the frame as a direct message to receiverID, must be called before the frame is sent to any client
+func (f *Frame) deliverTo(receiverID, messageID string) {
+	f.receiverID, f.directMessageID = receiverID, messageID
+}
+
+// wraps an encoded WebSocketMessage, e.g. one published by another instance
+func NewFrame(data []byte) *Frame {
+	frame := &Frame{JSON: data}
+	if wsMessage, err := Decode[protocol.WebSocketMessage](data); err == nil {
+		frame.Type = wsMessage.Type
+	}
+	return frame
+}
+
+// the frame encoded for a client using the msgpack subprotocol
+// it is converted from the JSON encoding so times and IDs look the same in both
+func (f *Frame) Msgpack() ([]byte, error) {
+	f.once.Do(func() {
+		f.msgpack, f.err = jsonToMsgpack(f.JSON)
+	})
+	return f.msgpack, f.err
+}
+
+// same fields as WebSocketMessage, the payload is encoded along with it instead of separately
+type outboundMessage struct {
+	Type    protocol.MessageType `json:"type"`
+	Payload any                  `json:"payload"`
+}
+
+// encodes a payload and wraps it in a WebSocketMessage of the given type
+func EncodeWsMessage(msgType protocol.MessageType, payload any) (*Frame, error) {
+	data, err := Encode(outboundMessage{Type: msgType, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Type: msgType, JSON: data}, nil
+}
+
+// converts JSON to MessagePack, integers stay integers
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("Error decoding frame for msgpack: %w", err)
+	}
+	out, err := msgpack.Marshal(fromJSONNumbers(v))
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding frame as msgpack: %w", err)
+	}
+	return out, nil
+}
+
+// replaces json.Number values decoded with UseNumber by int64 or float64
+func fromJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, value := range v {
+			v[key] = fromJSONNumbers(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = fromJSONNumbers(value)
+		}
+	}
+	return v
+}
+
+// converts an inbound MessagePack message to JSON so it is dispatched like any other
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("Error decoding msgpack message: %w", err)
+	}
+	return Encode(v)
+}
@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"bytes"
+	"chatapp/pkg/protocol"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// fills every field of v with a non-zero value, so omitempty fields and pointers are encoded too
+func fill(t *testing.T, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("value é 😀")
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1 << 40)
+	case reflect.Float64:
+		v.SetFloat(0.25)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(t, v.Elem())
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := range v.Len() {
+			fill(t, v.Index(i))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key, value := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fill(t, key)
+		fill(t, value)
+		v.SetMapIndex(key, value)
+	case reflect.Struct:
+		if v.Type() == reflect.TypeFor[time.Time]() {
+			v.Set(reflect.ValueOf(time.Date(2024, 2, 29, 23, 59, 58, 123456789, time.UTC)))
+			return
+		}
+		for i := range v.NumField() {
+			fill(t, v.Field(i))
+		}
+	default:
+		t.Fatalf("fill doesn't handle %s", v.Type())
+	}
+}
+
+// encodes a payload into a frame, sends it to a msgpack client and reads it back like an inbound message
+func roundTrip(t *testing.T, messageType protocol.MessageType, payload any) (*Frame, *protocol.WebSocketMessage) {
+	t.Helper()
+	frame, err := EncodeWsMessage(messageType, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := frame.Msgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := msgpackToJSON(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsMessage, err := Decode[protocol.WebSocketMessage](data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wsMessage.Type != messageType {
+		t.Fatalf("got type %q, want %q", wsMessage.Type, messageType)
+	}
+	return frame, wsMessage
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	for messageType, payloadType := range protocol.PayloadTypes {
+		for _, filled := range []bool{true, false} {
+			name := string(messageType) + "/zero"
+			if filled {
+				name = string(messageType) + "/filled"
+			}
+			t.Run(name, func(t *testing.T) {
+				want := reflect.New(payloadType)
+				if filled {
+					fill(t, want.Elem())
+				}
+				frame, wsMessage := roundTrip(t, messageType, want.Interface())
+
+				got := reflect.New(payloadType)
+				if err := json.Unmarshal(wsMessage.Payload, got.Interface()); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got.Interface(), want.Interface()) {
+					t.Errorf("got %+v\nwant %+v", got.Elem(), want.Elem())
+				}
+				// clients of either encoding see the same fields, e.g. omitted pointers stay omitted
+				var fromJSON, fromMsgpack any
+				json.Unmarshal(frame.JSON, &fromJSON)
+				json.Unmarshal(wsMessage.Payload, &fromMsgpack)
+				if !reflect.DeepEqual(fromJSON.(map[string]any)["payload"], fromMsgpack) {
+					t.Errorf("msgpack payload %v differs from JSON %v", fromMsgpack, fromJSON)
+				}
+			})
+		}
+	}
+}
+
+func TestMsgpackKeepsIntegers(t *testing.T) {
+	frame, err := EncodeWsMessage(protocol.UserList, map[string]any{"count": 3, "ratio": 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := frame.Msgpack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Payload map[string]any `msgpack:"payload"`
+	}
+	if err := msgpack.Unmarshal(packed, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if count := reflect.ValueOf(decoded.Payload["count"]); !count.CanInt() || count.Int() != 3 {
+		t.Errorf("count was encoded as %T %v", decoded.Payload["count"], decoded.Payload["count"])
+	}
+	if decoded.Payload["ratio"] != 0.5 {
+		t.Errorf("ratio was encoded as %v", decoded.Payload["ratio"])
+	}
+}
+
+func TestFrameMsgpackIsEncodedOnce(t *testing.T) {
+	frame, err := EncodeWsMessage(protocol.Chat, protocol.ChatMessageData{Text: "hello", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every client of a broadcast asks for the encoding at once
+	results := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = frame.Msgpack()
+		}()
+	}
+	wg.Wait()
+	for _, packed := range results[1:] {
+		if &packed[0] != &results[0][0] {
+			t.Fatal("frame was encoded more than once")
+		}
+	}
+}
+
+func TestNewFrameType(t *testing.T) {
+	frame, err := EncodeWsMessage(protocol.Typing, protocol.TypingData{UserID: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := NewFrame(frame.JSON); got.Type != protocol.Typing || !bytes.Equal(got.JSON, frame.JSON) {
+		t.Errorf("got frame %q of type %q", got.JSON, got.Type)
+	}
+	if got := NewFrame([]byte("not json")); got.Type != "" {
+		t.Errorf("got type %q for invalid JSON", got.Type)
+	}
+}
+
+func TestMsgpackToJSONRejectsInvalidInput(t *testing.T) {
+	if _, err := msgpackToJSON([]byte{0xc1}); err == nil { // never used byte in MessagePack
+		t.Error("no error for invalid msgpack")
+	}
+}
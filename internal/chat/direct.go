@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"chatapp/internal/postgres"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// send buffer slots kept free after queued direct messages for the user list and notifications
+const pendingDirectReserve = 8
+
+// fills in the sender details of a direct message with a resolved receiver and persists it
+// returns true if the message is a retransmission that was already stored
+func saveDirectMessage(chatMessageData *protocol.ChatMessageData, c *Client) (bool, error) {
+	chatMessageData.SenderID = c.ID
+	chatMessageData.SenderUsername = c.Username()
+	// direct messages are not tied to a room or thread
+	chatMessageData.RoomID = ""
+	chatMessageData.ParentMessageID = ""
+
+	id, createdAt, duplicate, err := postgres.CreateDirectMessage(c.ID, chatMessageData.ReceiverID, chatMessageData.Text, chatMessageData.ClientMsgID)
+	if err != nil {
+		return false, fmt.Errorf("Error saving direct message from %s: %w", c.Username(), err)
+	}
+	chatMessageData.MessageID = id
+	chatMessageData.Time = createdAt
+	return duplicate, nil
+}
+
+// fills in the receivers ID and username from whichever one the client sent
+func resolveReceiver(chatMessageData *protocol.ChatMessageData) error {
+	id, username, err := resolveUser(chatMessageData.ReceiverID, chatMessageData.ReceiverUsername)
+	if err != nil {
+		return fmt.Errorf("Error resolving direct message receiver: %w", err)
+	}
+	chatMessageData.ReceiverID, chatMessageData.ReceiverUsername = id, username
+	return nil
+}
+
+var errMissingUser = errors.New("Missing user ID or username.")
+
+// looks up a users ID and username from whichever one a client sent
+// returns sql.ErrNoRows if there is no such user
+func resolveUser(id, username string) (string, string, error) {
+	var err error
+	switch {
+	case id != "":
+		if !protocol.IsID(id) {
+			return "", "", sql.ErrNoRows
+		}
+		username, err = postgres.GetUsernameById(id)
+	case username != "":
+		id, err = postgres.GetUserIdByUsername(username)
+	default:
+		return "", "", errMissingUser
+	}
+	return id, username, err
+}
+
+// tells the client why the user it named couldn't be looked up, only a missing user is not found
+func sendResolveError(c *Client, correlationID string, who string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		sendError(c, correlationID, protocol.CodeNotFound, who+" not found.")
+	case errors.Is(err, errMissingUser):
+		sendError(c, correlationID, protocol.CodeBadPayload, errMissingUser.Error())
+	default:
+		log.Println(err)
+		sendError(c, correlationID, protocol.CodeInternal, "Failed to look up "+strings.ToLower(who)+".")
+	}
+}
+
+// marks a direct message delivered once it was written to one of the receivers connections
+func markDelivered(messageID string) {
+	if err := postgres.MarkMessagesDelivered([]string{messageID}); err != nil {
+		log.Printf("Error marking message %s delivered: %v", messageID, err)
+	}
+}
+
+// queues direct messages sent while the user was offline on the clients send buffer, each is marked delivered once
+// it is written to the connection
+// called before the client is registered so they come before live messages, and once after in case one was stored in
+// between and missed the live delivery, messages already queued aren't sent twice
+// anything that doesn't fit in the buffer stays queued until the next connection
+func (c *Client) SendPendingDirectMessages() {
+	space := cap(c.Send) - len(c.Send) - pendingDirectReserve
+	if space <= 0 {
+		return
+	}
+	rows, err := postgres.GetUndeliveredDirectMessages(c.ID, space)
+	if err != nil {
+		log.Printf("Error loading queued direct messages for %s: %v", c.Username(), err)
+		return
+	}
+	for _, row := range rows {
+		chatMessageData := chatMessageFromRow(row)
+		chatMessageData.ReceiverUsername = c.Username()
+		data, err := EncodeWsMessage(protocol.DirectMessage, chatMessageData)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		data.deliverTo(c.ID, row.ID)
+		if !c.trySend(data) {
+			return
+		}
+	}
+	if len(rows) > 0 {
+		log.Printf("Queued %d direct messages sent to %s while offline", len(rows), c.Username())
+	}
+}
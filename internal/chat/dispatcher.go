@@ -1,77 +1,258 @@
 package chat
 
 import (
+	"chatapp/internal/postgres"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 )
 
+// longest client message ID accepted, enough for a UUID or ULID with a prefix
+const maxClientMsgIDLength = 64
+
+var clientMsgIDTooLong = fmt.Sprintf("client_msg_id can be at most %d characters.", maxClientMsgIDLength)
+
 // decodes an inbound message, parses message type, and sends it to correct Hub channel
+// every rejected message gets an error frame sent back to the client, unless it is rejected for being sent too
+// often by a client that is being disconnected for it
 func dispatch(c *Client, data []byte) {
-	wsMessage, err := Decode[WebSocketMessage](data)
+	wsMessage, err := Decode[protocol.WebSocketMessage](data)
 	if err != nil {
 		log.Println(err)
+		if allowMessage(c, "", "") {
+			sendError(c, "", protocol.CodeMalformed, "Message must be a JSON object with a type and payload.")
+		}
+		return
+	}
+	if !allowMessage(c, wsMessage.Type, wsMessage.ID) {
 		return
 	}
+	if wsMessage.Type != protocol.Hello {
+		welcomeWithoutHello(c)
+	}
 	switch wsMessage.Type {
-	case Chat:
-		// messages from clients should only contain Text in payload
-		chatMessageData, err := Decode[ChatMessageData](wsMessage.Payload)
-		if err != nil {
+	case protocol.Hello:
+		dispatchHello(c, wsMessage)
+	case protocol.Chat:
+		dispatchInboundChat(c, wsMessage)
+	case protocol.DirectMessage:
+		dispatchInboundDirectMessage(c, wsMessage)
+	case protocol.MessageEdit, protocol.MessageDelete:
+		dispatchMessageEdit(c, wsMessage)
+	case protocol.React:
+		dispatchReact(c, wsMessage)
+	case protocol.ReadUpTo:
+		dispatchReadUpTo(c, wsMessage)
+	case protocol.Presence:
+		dispatchSetStatus(c, wsMessage)
+	case protocol.TypingStart, protocol.TypingStop:
+		dispatchTyping(c, wsMessage)
+	case protocol.JoinRoom:
+		dispatchJoinRoom(c, wsMessage)
+	case protocol.LeaveRoom:
+		dispatchLeaveRoom(c, wsMessage)
+	case protocol.Kick, protocol.Ban, protocol.Unban, protocol.Mute, protocol.Unmute:
+		dispatchModeration(c, wsMessage)
+	case protocol.UsernameUpdate:
+		dispatchUsernameUpdate(c, wsMessage)
+	default:
+		log.Printf("Unsupported WebSocket message type %q from %s", wsMessage.Type, c.Username())
+		sendError(c, wsMessage.ID, protocol.CodeUnknownType, fmt.Sprintf("Unsupported message type %q.", wsMessage.Type))
+	}
+}
+
+// handles a chat message sent to one of the clients rooms
+func dispatchInboundChat(c *Client, wsMessage *protocol.WebSocketMessage) {
+	// messages from clients should only contain Text in payload
+	chatMessageData, err := Decode[protocol.ChatMessageData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid chat message payload.")
+		return
+	}
+	if len(chatMessageData.ClientMsgID) > maxClientMsgIDLength {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, clientMsgIDTooLong)
+		return
+	}
+	if err := validateText(chatMessageData.Text); err != nil {
+		sendTextError(c, wsMessage.ID, err)
+		return
+	}
+	roomID, ok := resolveRoom(c, wsMessage, chatMessageData.RoomID)
+	if !ok {
+		return
+	}
+	chatMessageData.RoomID = roomID
+	// muted users can't send chat messages to the room
+	if err := rooms.CheckCanChat(roomID, c.ID); err != nil {
+		if errors.Is(err, rooms.ErrMuted) {
+			sendError(c, wsMessage.ID, protocol.CodeMuted, err.Error())
+		} else {
 			log.Println(err)
+			sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to send message.")
+		}
+		return
+	}
+	// replies must be to a message in the same room that isn't itself a reply
+	if chatMessageData.ParentMessageID != "" {
+		if err := checkReplyParent(roomID, chatMessageData.ParentMessageID); err != nil {
+			switch {
+			case errors.Is(err, ErrMessageNotFound):
+				sendError(c, wsMessage.ID, protocol.CodeNotFound, "Parent message not found.")
+			case errors.Is(err, ErrNestedReply):
+				sendError(c, wsMessage.ID, protocol.CodeBadPayload, err.Error())
+			default:
+				log.Println(err)
+				sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to send message.")
+			}
 			return
 		}
-		// after reading in only the text from message, update the rest of message with client details
-		updateChatMessageData(chatMessageData, c)
-		// call dispatch to send to hub broadcast channel
+	}
+	// after reading in only the text from message, update the rest of message with client details
+	updateChatMessageData(chatMessageData, c)
+	// persist before broadcasting so the message gets its server assigned ID and time
+	duplicate, err := saveChatMessage(chatMessageData)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to send message.")
+		return
+	}
+	// retransmissions were already broadcast, only acknowledge them again
+	if !duplicate {
+		// replies carry the updated thread so clients can update the parent
+		if chatMessageData.ParentMessageID != "" {
+			if chatMessageData.Thread, err = loadThreadSummary(chatMessageData.ParentMessageID); err != nil {
+				log.Println(err)
+			}
+		}
+		// call dispatch to send to the rooms shard
 		dispatchChatMessage(c.Hub, *chatMessageData)
+	}
+	sendAck(c, chatMessageData, duplicate)
+}
 
-	case UsernameUpdate:
-		usernameUpdateData, err := Decode[UsernameUpdateData](wsMessage.Payload)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		usernameUpdateData.Client = c
-		c.Hub.usernameUpdate <- *usernameUpdateData
-	default:
-		log.Println("Unsupported WebSocket message type")
+// handles a direct message sent to another user
+func dispatchInboundDirectMessage(c *Client, wsMessage *protocol.WebSocketMessage) {
+	chatMessageData, err := Decode[protocol.ChatMessageData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid direct message payload.")
+		return
+	}
+	if len(chatMessageData.ClientMsgID) > maxClientMsgIDLength {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, clientMsgIDTooLong)
+		return
+	}
+	if err := validateText(chatMessageData.Text); err != nil {
+		sendTextError(c, wsMessage.ID, err)
+		return
+	}
+	if err := resolveReceiver(chatMessageData); err != nil {
+		sendResolveError(c, wsMessage.ID, "Receiver", err)
+		return
+	}
+	// fills in sender details and persists the message
+	duplicate, err := saveDirectMessage(chatMessageData, c)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to send direct message.")
+		return
+	}
+	if !duplicate {
+		dispatchDirectMessage(c.Hub, *chatMessageData)
+	}
+	sendAck(c, chatMessageData, duplicate)
+}
+
+// handles a client telling the hub it changed its username through the REST endpoint
+func dispatchUsernameUpdate(c *Client, wsMessage *protocol.WebSocketMessage) {
+	usernameUpdateData, err := Decode[protocol.UsernameUpdateData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid username update payload.")
+		return
 	}
+	// the new username must already be saved, otherwise clients could show any name in the user list
+	username, err := postgres.GetUsernameById(c.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to update username.")
+		return
+	}
+	if username != usernameUpdateData.Username {
+		sendError(c, wsMessage.ID, protocol.CodePermissionDenied, "Username doesn't match your account.")
+		return
+	}
+	c.Hub.updateUsername(c, usernameUpdateData.Username)
+}
+
+// acknowledges a stored message to its sender, only messages sent with a client message ID are acknowledged
+func sendAck(c *Client, chatMessageData *protocol.ChatMessageData, duplicate bool) {
+	if chatMessageData.ClientMsgID == "" {
+		return
+	}
+	data, err := EncodeWsMessage(protocol.Ack, protocol.AckData{
+		ClientMsgID: chatMessageData.ClientMsgID,
+		MessageID:   chatMessageData.MessageID,
+		Time:        chatMessageData.Time,
+		Duplicate:   duplicate,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.trySend(data)
 }
 
 // updates a chat message with the details of the sender client who is broadcasting it
-func updateChatMessageData(chatMessageData *ChatMessageData, c *Client) {
+func updateChatMessageData(chatMessageData *protocol.ChatMessageData, c *Client) {
 	chatMessageData.SenderID = c.ID
-	chatMessageData.SenderUsername = c.Username
+	chatMessageData.SenderUsername = c.Username()
 	chatMessageData.Time = time.Now()
-	chatMessageData.RoomID = c.RoomID
+	// only set by the server on stored messages
+	chatMessageData.EditedAt = nil
+	chatMessageData.Deleted = false
+	chatMessageData.Reactions = nil
+	chatMessageData.ReplyCount = 0
+	chatMessageData.LastReplyAt = nil
+	chatMessageData.Thread = nil
 }
 
-// notifications to a room when a new client joins or leaves the room
+// notifications to a room, such as moderation notices
 func dispatchNotification(hub *Hub, roomID string, text string) {
-	chatMessageData := ChatMessageData{
-		SenderID: NotificationSenderID,
+	dispatchChatMessage(hub, notificationData(roomID, text))
+}
+
+// a server notification shown in a room
+func notificationData(roomID, text string) protocol.ChatMessageData {
+	return protocol.ChatMessageData{
+		SenderID: protocol.NotificationSenderID,
 		RoomID:   roomID,
 		Text:     text,
 		Time:     time.Now(),
 	}
-	dispatchChatMessage(hub, chatMessageData)
 }
 
-// enqueues a message a to hub broadcast channel to get sent to the room
-func dispatchChatMessage(hub *Hub, chatMessageData ChatMessageData) {
-	data, err := Encode(chatMessageData)
+// enqueues a message to the rooms shard to get sent to the room
+func dispatchChatMessage(hub *Hub, chatMessageData protocol.ChatMessageData) {
+	data, err := EncodeWsMessage(protocol.Chat, chatMessageData)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	outboundWsMessage := WebSocketMessage{
-		Type:    Chat,
-		Payload: data,
-	}
-	data, err = Encode(outboundWsMessage)
+	hub.sendToRoom(ChatMessage{chatMessageData.RoomID, data, chatMessageData.SenderUsername, chatMessageData.Text})
+}
+
+// enqueues a direct message to the hub to get sent to the receiver
+func dispatchDirectMessage(hub *Hub, chatMessageData protocol.ChatMessageData) {
+	data, err := EncodeWsMessage(protocol.DirectMessage, chatMessageData)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	hub.broadcast <- ChatMessage{chatMessageData.RoomID, data, chatMessageData.SenderUsername, chatMessageData.Text}
+	hub.direct <- DirectChatMessage{chatMessageData.MessageID, chatMessageData.SenderID, chatMessageData.ReceiverID, data}
 }
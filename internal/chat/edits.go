@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"chatapp/internal/postgres"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// handles an inbound edit or delete of a stored message in one of the clients rooms
+func dispatchMessageEdit(c *Client, wsMessage *protocol.WebSocketMessage) {
+	editData, err := Decode[protocol.MessageEditData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid message edit payload.")
+		return
+	}
+	if wsMessage.Type == protocol.MessageEdit {
+		if err := validateText(editData.Text); errors.Is(err, ErrEmptyText) {
+			sendError(c, wsMessage.ID, protocol.CodeInvalidText, "Edited text can't be empty, delete the message instead.")
+			return
+		} else if err != nil {
+			sendTextError(c, wsMessage.ID, err)
+			return
+		}
+	}
+	roomID, ok := resolveRoom(c, wsMessage, editData.RoomID)
+	if !ok {
+		return
+	}
+	message, err := getRoomMessage(roomID, editData.MessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendError(c, wsMessage.ID, protocol.CodeNotFound, "Message not found.")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to change message.")
+		return
+	}
+	if err := checkCanEdit(c, roomID, message); err != nil {
+		switch {
+		case errors.Is(err, rooms.ErrNotModerator):
+			sendError(c, wsMessage.ID, protocol.CodePermissionDenied, "Only the sender or a room moderator can change this message.")
+		case errors.Is(err, rooms.ErrMuted):
+			sendError(c, wsMessage.ID, protocol.CodeMuted, err.Error())
+		default:
+			log.Println(err)
+			sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to change message.")
+		}
+		return
+	}
+
+	editData.RoomID = roomID
+	editData.EditorID = c.ID
+	if wsMessage.Type == protocol.MessageEdit {
+		editData.Time, err = postgres.EditMessage(message.ID, c.ID, editData.Text)
+	} else {
+		editData.Text = ""
+		editData.Time, err = postgres.DeleteMessage(message.ID, c.ID)
+	}
+	// the message was deleted between loading and changing it
+	if errors.Is(err, sql.ErrNoRows) {
+		sendError(c, wsMessage.ID, protocol.CodeNotFound, "Message not found.")
+		return
+	}
+	if err != nil {
+		log.Printf("Error changing message %s in Room %s: %v", message.ID, roomID, err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to change message.")
+		return
+	}
+	dispatchMessageChange(c.Hub, wsMessage.Type, *editData, c.Username())
+}
+
+// loads a message in a room, messages outside the room or already deleted are not found
+func getRoomMessage(roomID, messageID string) (postgres.Message, error) {
+	if !protocol.IsID(messageID) {
+		return postgres.Message{}, sql.ErrNoRows
+	}
+	message, err := postgres.GetMessage(messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return message, err
+		}
+		return message, fmt.Errorf("Error loading message %s: %w", messageID, err)
+	}
+	if message.RoomID != roomID || message.DeletedAt.Valid {
+		return message, sql.ErrNoRows
+	}
+	return message, nil
+}
+
+// senders can change their own messages unless they are muted, moderators can change any message in their room
+func checkCanEdit(c *Client, roomID string, message postgres.Message) error {
+	if message.SenderID == c.ID {
+		return rooms.CheckCanChat(roomID, c.ID)
+	}
+	return rooms.CheckIsModerator(roomID, c.ID)
+}
+
+// enqueues an edit or delete to the rooms shard to get sent to the room
+func dispatchMessageChange(hub *Hub, messageType protocol.MessageType, editData protocol.MessageEditData, editorUsername string) {
+	data, err := EncodeWsMessage(messageType, editData)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	logText := fmt.Sprintf("[%s %s] %s", messageType, editData.MessageID, editData.Text)
+	hub.sendToRoom(ChatMessage{editData.RoomID, data, editorUsername, logText})
+}
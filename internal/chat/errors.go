@@ -0,0 +1,25 @@
+package chat
+
+import (
+	"chatapp/pkg/protocol"
+	"log"
+)
+
+// sends an error frame to a single client through the hub, correlationID is the ID of the rejected message
+func sendError(c *Client, correlationID string, code protocol.ErrorCode, message string) {
+	data, err := EncodeWsMessage(protocol.Error, protocol.ErrorData{Code: code, Message: message, CorrelationID: correlationID})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.trySend(data)
+}
+
+// best effort read of the correlation ID from a message that is being rejected before it is dispatched
+func peekCorrelationID(data []byte) string {
+	wsMessage, err := Decode[protocol.WebSocketMessage](data)
+	if err != nil {
+		return ""
+	}
+	return wsMessage.ID
+}
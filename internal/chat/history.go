@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/internal/postgres"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// persists a chat message from a client and sets the server assigned message ID and time
+// returns true if the message is a retransmission that was already stored
+func saveChatMessage(chatMessageData *protocol.ChatMessageData) (bool, error) {
+	id, createdAt, duplicate, err := postgres.CreateRoomMessage(chatMessageData.RoomID, chatMessageData.SenderID, chatMessageData.ParentMessageID, chatMessageData.Text, chatMessageData.ClientMsgID)
+	if err != nil {
+		return false, fmt.Errorf("Error saving message from %s in Room %s: %w", chatMessageData.SenderUsername, chatMessageData.RoomID, err)
+	}
+	chatMessageData.MessageID = id
+	chatMessageData.Time = createdAt
+	return duplicate, nil
+}
+
+// converts a stored message row into the chat message payload sent to clients
+func chatMessageFromRow(m postgres.Message) protocol.ChatMessageData {
+	chatMessageData := protocol.ChatMessageData{
+		MessageID:       m.ID,
+		SenderID:        m.SenderID,
+		SenderUsername:  m.SenderUsername,
+		ReceiverID:      m.ReceiverID,
+		RoomID:          m.RoomID,
+		Text:            m.Text,
+		Time:            m.CreatedAt,
+		Deleted:         m.DeletedAt.Valid,
+		ParentMessageID: m.ParentID,
+		ReplyCount:      m.ReplyCount,
+	}
+	if m.EditedAt.Valid {
+		chatMessageData.EditedAt = &m.EditedAt.Time
+	}
+	if m.LastReplyAt.Valid {
+		chatMessageData.LastReplyAt = &m.LastReplyAt.Time
+	}
+	return chatMessageData
+}
+
+// a page of room history returned by the REST history endpoint
+type HistoryPage struct {
+	Messages   []protocol.ChatMessageData `json:"messages"`              // ordered oldest to newest
+	NextBefore string                     `json:"next_before,omitempty"` // cursor for the next older page, empty when there are no older messages
+}
+
+// loads the most recent messages in a room, ordered oldest to newest
+// reactions are marked with whether userID reacted
+func LoadRecentMessages(roomID, userID string, limit int) ([]protocol.ChatMessageData, error) {
+	return LoadMessagesBefore(roomID, userID, "", limit)
+}
+
+// loads messages in a room sent before the message with id before, ordered oldest to newest
+// reactions are marked with whether userID reacted
+func LoadMessagesBefore(roomID, userID, before string, limit int) ([]protocol.ChatMessageData, error) {
+	rows, err := postgres.GetRoomMessagesBefore(roomID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading history for Room %s: %w", roomID, err)
+	}
+	messages := make([]protocol.ChatMessageData, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, chatMessageFromRow(row))
+	}
+	if err := attachReactions(messages, userID); err != nil {
+		return nil, fmt.Errorf("Error loading reactions for Room %s: %w", roomID, err)
+	}
+	return messages, nil
+}
+
+// loads messages in a room sent after the message with id after, ordered oldest to newest
+// returns sql.ErrNoRows if after isn't a message in the room, deleted messages can still be resumed from
+func LoadMessagesAfter(roomID, userID, after string, limit int) ([]protocol.ChatMessageData, error) {
+	if !protocol.IsID(after) {
+		return nil, sql.ErrNoRows
+	}
+	message, err := postgres.GetMessage(after)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && message.RoomID != roomID) {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error loading message %s: %w", after, err)
+	}
+	rows, err := postgres.GetRoomMessagesAfter(roomID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading missed messages for Room %s: %w", roomID, err)
+	}
+	messages := make([]protocol.ChatMessageData, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, chatMessageFromRow(row))
+	}
+	if err := attachReactions(messages, userID); err != nil {
+		return nil, fmt.Errorf("Error loading reactions for Room %s: %w", roomID, err)
+	}
+	return messages, nil
+}
+
+// loads a page of older room history, fetching one extra message to know if another page exists
+func LoadHistoryPage(roomID, userID, before string, limit int) (*HistoryPage, error) {
+	messages, err := LoadMessagesBefore(roomID, userID, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &HistoryPage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[1:] // drop the extra oldest message
+		page.NextBefore = page.Messages[0].MessageID
+	}
+	return page, nil
+}
+
+// queues recent history of the clients initial room on its send buffer
+// must be called before the client is registered so the hub can't close Send underneath it
+func (c *Client) SendHistory(limit int) {
+	if c.RoomID == "" {
+		return
+	}
+	// leave room in the send buffer for the user list and join notification after registering
+	frames, err := historyFrames(c.RoomID, c.ID, min(limit, cap(c.Send)/2))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, data := range frames {
+		c.Send <- data
+	}
+}
+
+// queues the messages a reconnecting client missed in its initial room since lastSeenID, in place of SendHistory
+// must be called before the client is registered so the hub can't close Send underneath it
+func (c *Client) SendMissedMessages(lastSeenID string) {
+	if c.RoomID == "" {
+		return
+	}
+	frames, err := resumeFrames(c.RoomID, c.ID, lastSeenID, resumeLimit(c))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, data := range frames {
+		c.Send <- data
+	}
+}
+
+// most missed messages replayed to a reconnecting client, leaving room in its send buffer for live messages
+func resumeLimit(c *Client) int {
+	return cap(c.Send) / 2
+}
+
+// loads and encodes the messages a reconnecting client missed in a room after lastSeenID, preceded by a resume frame
+// if more than limit were missed, or lastSeenID isn't in the room, the recent history is sent instead and the resume
+// frame tells the client to replace the messages it has
+// edits, deletes and reactions made while the client was away aren't replayed
+func resumeFrames(roomID, userID, lastSeenID string, limit int) ([]*Frame, error) {
+	missed, err := LoadMessagesAfter(roomID, userID, lastSeenID, limit+1)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	resume := protocol.ResumeData{RoomID: roomID, LastSeenMessageID: lastSeenID, Complete: err == nil && len(missed) <= limit}
+	if !resume.Complete { // the gap can't be filled, start the client over from recent history
+		if missed, err = LoadRecentMessages(roomID, userID, min(limit, config.App.Chat.HistoryLimit)); err != nil {
+			return nil, err
+		}
+	}
+	resume.Replayed = len(missed)
+	data, err := EncodeWsMessage(protocol.Resume, resume)
+	if err != nil {
+		return nil, err
+	}
+	frames, err := chatFrames(missed)
+	return append([]*Frame{data}, frames...), err
+}
+
+// loads and encodes the most recent messages in a room, reactions are marked with whether userID reacted
+func historyFrames(roomID, userID string, limit int) ([]*Frame, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	messages, err := LoadRecentMessages(roomID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return chatFrames(messages)
+}
+
+// encodes stored messages as chat frames
+func chatFrames(messages []protocol.ChatMessageData) ([]*Frame, error) {
+	frames := make([]*Frame, 0, len(messages))
+	for _, message := range messages {
+		data, err := EncodeWsMessage(protocol.Chat, message)
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, data)
+	}
+	return frames, nil
+}
@@ -1,44 +1,104 @@
 package chat
 
 import (
-	"fmt"
+	"chatapp/internal/broker"
+	"chatapp/pkg/protocol"
+	"hash/fnv"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
-// maintains active peer connections as clients and broadcasts messages
+// maintains active peer connections as clients and shards their rooms across worker goroutines
+// the hub goroutine owns everything about users (their clients, direct messages and presence) while each
+// roomShard owns a subset of rooms, so a busy room only slows down the rooms sharing its shard
 type Hub struct {
-	// hashmap of Key:RoomID, Value: hashset of pointers to clients
-	rooms map[string]map[*Client]struct{}
+	// room workers, a room always goes to the same shard
+	shards []*roomShard
+
+	// hashmap of Key:UserID, Value: hashset of the users connected clients across all rooms
+	users map[string]map[*Client]struct{}
+
+	direct chan DirectChatMessage // private messages delivered to a single user
+
+	setStatus chan StatusRequest // users choosing to show as away or do not disturb
+
+	// hashmap of Key:UserID, Value: presence of every user connected to this or another instance
+	presence map[string]*presence
+
+	// hashmap of Key:UserID, Value: hashset of room IDs to send the users new status to after the current event
+	presenceChanged map[string]map[string]struct{}
 
-	// inbound messages from peers, unbuffered for backpressure
-	// don't want a single client taking up a buffered channel with spam messages
-	broadcast chan ChatMessage
+	// hashmap of Key:UserID, Value: status shown in user lists, written by the hub goroutine and read by shards
+	statusMu sync.RWMutex
+	statuses map[string]protocol.Status
 
-	usernameUpdate chan UsernameUpdateData // used to update client name for broadcasting new user list
+	broker broker.Broker // shares broadcasts, user lists and presence with other server instances
+
+	instance string // ID of this hub in broker events
+
+	// hashmap of Key:instance ID, Value: when another instance last published an event
+	instances map[string]time.Time
 
 	// clients to register to Hub
 	register chan *Client
 
 	// clients to unregister from Hub
 	unregister chan *Client
+
+	shutdown chan struct{} // closes every client when the server is shutting down
+
+	closing atomic.Bool // set once shutdown starts, clients registering after it are closed straight away
+	// guards setting closing and adding connections, so no connection is added once Shutdown may be waiting
+	connMu sync.Mutex
+
+	connections sync.WaitGroup // client read and write goroutines and database writes Shutdown waits for
 }
 
 type ChatMessage struct {
 	RoomID         string // room ID to broadcast message
-	Data           []byte // encoded WebSocket data including payload
+	Data           *Frame // encoded WebSocket data including payload
 	SenderUsername string // using for logging
 	MessageText    string // using for logging
 }
 
-// create and return pointer to new Hub
-func NewHub() *Hub {
-	return &Hub{
-		rooms:          make(map[string]map[*Client]struct{}),
-		broadcast:      make(chan ChatMessage),
-		usernameUpdate: make(chan UsernameUpdateData),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
+type DirectChatMessage struct {
+	MessageID  string // used to mark the message delivered once it is written to the receiver
+	SenderID   string // sender also receives a copy for their other clients
+	ReceiverID string // user ID to deliver message to
+	Data       *Frame // encoded WebSocket data including payload
+}
+
+type KickRequest struct {
+	RoomID string // room to remove the user from
+	UserID string // every client of this user in the room is disconnected
+	Reason string // sent to the kicked clients in the close frame
+}
+
+// create and return pointer to new Hub with its rooms split across shards worker goroutines
+// b is shared with every other instance serving the same rooms
+func NewHub(b broker.Broker, shards int) *Hub {
+	h := &Hub{
+		users:           make(map[string]map[*Client]struct{}),
+		direct:          make(chan DirectChatMessage),
+		setStatus:       make(chan StatusRequest),
+		presence:        make(map[string]*presence),
+		presenceChanged: make(map[string]map[string]struct{}),
+		statuses:        make(map[string]protocol.Status),
+		broker:          b,
+		instance:        newInstanceID(),
+		instances:       make(map[string]time.Time),
+		register:        make(chan *Client),
+		unregister:      make(chan *Client),
+		shutdown:        make(chan struct{}),
 	}
+	for range max(shards, 1) {
+		h.shards = append(h.shards, newRoomShard(h))
+	}
+	return h
 }
 
 func (h *Hub) RegisterClient(c *Client) {
@@ -49,103 +109,127 @@ func (h *Hub) UnregisterClient(c *Client) {
 	h.unregister <- c
 }
 
-// manage clients and broadcasting messages
+// the shard that owns a room
+func (h *Hub) shardFor(roomID string) *roomShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(roomID))
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// enqueues an encoded message to the rooms shard to get sent to the room
+func (h *Hub) sendToRoom(message ChatMessage) {
+	h.shardFor(message.RoomID).broadcast <- message
+}
+
+// changes a clients username and sends the new name in the user list of every room it is in
+func (h *Hub) updateUsername(c *Client, username string) {
+	c.setUsername(username)
+	for _, roomID := range c.joinedRooms() {
+		h.shardFor(roomID).refresh <- roomID
+	}
+}
+
+// starts the room shards and manages users, direct messages and presence
+// shards never wait on the hub goroutine, so it can always hand them work without deadlocking
 func (h *Hub) Run() {
+	for _, shard := range h.shards {
+		go shard.run()
+	}
+	presenceTicker := time.NewTicker(presenceSweepInterval)
+	defer presenceTicker.Stop()
+	brokerMessages := h.broker.Messages()
+	h.publish(brokerEvent{Kind: syncEvent}) // ask running instances for their rooms and presence
 	for {
 		select {
 		case client := <-h.register:
 			h.handleRegisterClient(client)
 		case client := <-h.unregister:
 			h.handleUnregisterClient(client)
-		case chatMessage := <-h.broadcast:
-			h.handleBroadcastChatMessage(chatMessage)
-		case usernameUpdate := <-h.usernameUpdate:
-			h.handleUsernameUpdate(usernameUpdate)
+		case directMessage := <-h.direct:
+			h.handleDirectMessage(directMessage)
+		case request := <-h.setStatus:
+			h.handleSetStatus(request)
+		case <-h.shutdown:
+			h.handleShutdown()
+		case now := <-presenceTicker.C:
+			h.sweepPresence()
+			h.sweepInstances(now)
+		case data, ok := <-brokerMessages:
+			if !ok {
+				log.Println("Broker closed, hub is only serving local clients")
+				brokerMessages = nil
+				break
+			}
+			h.handleBrokerMessage(data)
 		}
+		h.flushPresence()
 	}
 }
 
-// creates a client for a newly connected peer and registers to a room
+// registers a newly connected peer and joins its initial room if it connected with one
 func (h *Hub) handleRegisterClient(c *Client) {
-	if h.rooms[c.RoomID] == nil { // if room doesn't exist yet create a new one
-		h.rooms[c.RoomID] = make(map[*Client]struct{})
+	if h.users[c.ID] == nil {
+		h.users[c.ID] = make(map[*Client]struct{})
+	}
+	h.users[c.ID][c] = struct{}{}
+	if h.closing.Load() { // connected just as the server started shutting down
+		c.closeSend(websocket.CloseServiceRestart, restartReason)
+		return
+	}
+	h.updatePresence(c.ID) // before joining so the user list shows them online
+	log.Printf("%s has connected", c.Username())
+	if c.RoomID != "" {
+		h.shardFor(c.RoomID).join <- RoomRequest{Client: c, RoomID: c.RoomID, Initial: true}
 	}
-	h.rooms[c.RoomID][c] = struct{}{}
-	h.broadcastActiveUserList(c.RoomID)
-
-	msg := fmt.Sprintf("%s has joined Room %s ", c.Username, c.RoomID)
-	go dispatchNotification(h, c.RoomID, msg)
-
-	log.Printf("%s has joined Room %s ", c.Username, c.RoomID)
 }
 
-// removes a client from their current room
+// removes a client from the hub and every room it joined
+// clients a shard already disconnected, e.g. kicked or too slow, stay registered until their read goroutine stops
 func (h *Hub) handleUnregisterClient(c *Client) {
-	close(c.Send)
-	delete(h.rooms[c.RoomID], c) // remove client from room
-
-	msg := fmt.Sprintf("%s has left Room %s ", c.Username, c.RoomID)
-	go dispatchNotification(h, c.RoomID, msg)
-
-	if len(h.rooms[c.RoomID]) == 0 { // delete room if it's empty
-		delete(h.rooms, c.RoomID)
-		log.Printf("Deleted empty Room %s.", c.RoomID)
-	} else {
-		h.broadcastActiveUserList(c.RoomID) // broadcast to the room current active users
+	if !h.isConnected(c) {
+		return
 	}
+	c.closeSend(0, "")
+	// Send is closed so no shard can add the client to another room
+	roomIDs := c.joinedRooms()
+	for _, roomID := range roomIDs {
+		h.shardFor(roomID).leave <- RoomRequest{Client: c, RoomID: roomID, Disconnected: true}
+	}
+	h.removeUserClient(c)
+	h.updatePresence(c.ID, roomIDs...) // goes offline if this was their last client
 }
 
-// handler for broadcasting chat messages
-func (h *Hub) handleBroadcastChatMessage(message ChatMessage) {
-	log.Printf("(Room %s) %s: %s", message.RoomID, message.SenderUsername, message.MessageText)
-	h.broadcastData(message.RoomID, message.Data)
-}
-
-func (h *Hub) handleUsernameUpdate(update UsernameUpdateData) {
-	update.Client.Username = update.Username
-	h.broadcastActiveUserList(update.Client.RoomID)
+// handler for delivering a direct message to the receivers clients and the senders other clients
+func (h *Hub) handleDirectMessage(message DirectChatMessage) {
+	log.Printf("(Direct) %s -> %s", message.SenderID, message.ReceiverID)
+	// marked delivered once a receivers connection writes it, here or on another instance, otherwise it stays queued
+	// until the receiver next connects
+	message.Data.deliverTo(message.ReceiverID, message.MessageID)
+	h.sendToUser(message.ReceiverID, message.Data)
+	if message.SenderID != message.ReceiverID {
+		h.sendToUser(message.SenderID, message.Data)
+		h.publish(brokerEvent{Kind: userEvent, UserID: message.SenderID, Data: message.Data.JSON})
+	}
+	h.publish(brokerEvent{Kind: userEvent, UserID: message.ReceiverID, MessageID: message.MessageID, Data: message.Data.JSON})
 }
 
-// sends updated list of active users in a room
-// executes whenever a new client connects, disconnects, or changes name
-func (h *Hub) broadcastActiveUserList(RoomID string) {
-	room := h.rooms[RoomID]
-	if room == nil {
-		log.Println("Tried to broadcast to empty room")
-		return
-	}
-	var users []UserItem
-	for client := range room {
-		users = append(users, UserItem{ID: client.ID, Username: client.Username})
+// sends an encoded WebSocketMessage to every connected client of a user
+func (h *Hub) sendToUser(userID string, frame *Frame) {
+	for client := range h.users[userID] {
+		client.trySend(frame)
 	}
+}
 
-	payload, err := Encode(UserListMessage{Users: users})
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	data, err := Encode(WebSocketMessage{Type: UserList, Payload: payload})
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	// broadcast active user list to all clients
-	h.broadcastData(RoomID, data)
+// returns true if the client is registered and hasn't been unregistered
+func (h *Hub) isConnected(c *Client) bool {
+	_, ok := h.users[c.ID][c]
+	return ok
 }
 
-// broadcasts an encoded WebSocketMessage to all clients in the room
-func (h *Hub) broadcastData(RoomID string, data []byte) {
-	room := h.rooms[RoomID]
-	if room == nil {
-		log.Println("Tried to broadcast to empty room")
-		return
-	}
-	for client := range room { // push data to all clients send buffered channels
-		select {
-		case client.Send <- data:
-		default: // default disconnect if client send buffered channel full and being slow
-			close(client.Send)
-			delete(room, client)
-		}
+// removes a client from the user index
+func (h *Hub) removeUserClient(c *Client) {
+	delete(h.users[c.ID], c)
+	if len(h.users[c.ID]) == 0 {
+		delete(h.users, c.ID)
 	}
 }
@@ -0,0 +1,105 @@
+package chat
+
+import (
+	"chatapp/internal/broker"
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// broadcasts to rooms of a busy server from many senders at once, comparing a single shard like the old hub
+// with rooms spread across several shards, frames/s counts the broadcast frames clients received
+// shards only run in parallel with GOMAXPROCS above 1, on a single CPU they just add a channel hop
+// go test ./internal/chat -run '^$' -bench HubBroadcast -cpu 1,4,16
+func BenchmarkHubBroadcast(b *testing.B) {
+	log.SetOutput(io.Discard) // the hub logs every broadcast
+	defer log.SetOutput(os.Stderr)
+	config.App = &config.Config{Chat: &config.ChatConfig{}} // defaults the hub reads, no reconnect grace
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkHubBroadcast(b, shards, 5000, 250)
+		})
+	}
+}
+
+func benchmarkHubBroadcast(b *testing.B, shards, clients, rooms int) {
+	hub := NewHub(broker.NewMemory().Connect(), shards)
+	go hub.Run()
+
+	roomIDs := make([]string, rooms)
+	for i := range roomIDs {
+		roomIDs[i] = fmt.Sprintf("room-%d", i)
+	}
+	data, err := EncodeWsMessage(protocol.Chat, protocol.ChatMessageData{SenderID: "user-0", Text: "benchmark message"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	// every client drains its send buffer like a fast peer, counting only the benchmarks broadcast frames so join
+	// notices and user lists from connecting aren't counted
+	var received atomic.Int64
+	var drained sync.WaitGroup
+	connected := make([]*Client, clients)
+	for i := range connected {
+		c := NewClient(fmt.Sprintf("user-%d", i), fmt.Sprintf("user %d", i), roomIDs[i%rooms], hub, nil)
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			for frame := range c.Send {
+				if frame == data {
+					received.Add(1)
+				}
+			}
+		}()
+		hub.RegisterClient(c)
+		connected[i] = c
+	}
+	waitForRooms(b, hub, connected)
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			roomID := roomIDs[next.Add(1)%int64(rooms)]
+			hub.sendToRoom(ChatMessage{RoomID: roomID, Data: data})
+		}
+	})
+	// the frames are only received once every client drained them
+	want := int64(b.N) * int64(clients/rooms)
+	for deadline := time.Now().Add(10 * time.Second); received.Load() < want && time.Now().Before(deadline); {
+		runtime.Gosched()
+	}
+	b.StopTimer()
+	if got := received.Load(); got != want {
+		b.Fatalf("received %d broadcast frames, want %d", got, want)
+	}
+	b.ReportMetric(float64(received.Load())/b.Elapsed().Seconds(), "frames/s")
+
+	for _, c := range connected {
+		hub.UnregisterClient(c)
+	}
+	drained.Wait()
+}
+
+// waits until every client joined its initial room and each shard finished the work that queued up behind the joins
+func waitForRooms(tb testing.TB, hub *Hub, clients []*Client) {
+	deadline := time.Now().Add(10 * time.Second)
+	for _, c := range clients {
+		for !c.InRoom(c.RoomID) {
+			if time.Now().After(deadline) {
+				tb.Fatalf("%s never joined %s", c.ID, c.RoomID)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for _, shard := range hub.shards {
+		shard.refresh <- "" // handled after everything queued before it, there is no room to refresh
+	}
+}
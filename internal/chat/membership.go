@@ -0,0 +1,229 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// most rooms a single connection can be in at once
+const maxRoomsPerClient = 50
+
+type RoomRequest struct {
+	Client       *Client  // client joining or leaving
+	RoomID       string   // room to join or leave
+	History      []*Frame // encoded recent room messages sent to a joining client before it joins
+	Initial      bool     // joining the room_id query param room, its history was sent before registering
+	Disconnected bool     // leaving because the client disconnected, so it isn't sent a confirmation
+}
+
+// handles a connected client asking to join another room
+func dispatchJoinRoom(c *Client, wsMessage *protocol.WebSocketMessage) {
+	membershipData, err := Decode[protocol.RoomMembershipData](wsMessage.Payload)
+	if err != nil || membershipData.RoomID == "" {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid join room payload, room_id is required.")
+		return
+	}
+	roomID := membershipData.RoomID
+	if c.InRoom(roomID) {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, fmt.Sprintf("Already in Room %s.", roomID))
+		return
+	}
+	if len(c.joinedRooms()) >= maxRoomsPerClient {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, fmt.Sprintf("A connection can be in at most %d rooms.", maxRoomsPerClient))
+		return
+	}
+	// same checks as connecting with the room_id query param
+	if err := rooms.CheckJoinable(roomID, c.ID); err != nil {
+		switch {
+		case errors.Is(err, rooms.ErrNotFound):
+			sendError(c, wsMessage.ID, protocol.CodeNotFound, err.Error())
+		case errors.Is(err, rooms.ErrNotMember), errors.Is(err, rooms.ErrBanned), errors.Is(err, rooms.ErrArchived):
+			sendError(c, wsMessage.ID, protocol.CodePermissionDenied, err.Error())
+		default:
+			log.Println(err)
+			sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to join room.")
+		}
+		return
+	}
+	var history []*Frame
+	if membershipData.LastSeenMessageID != "" { // rejoining after a reconnect, only send what it missed
+		history, err = resumeFrames(roomID, c.ID, membershipData.LastSeenMessageID, resumeLimit(c))
+	} else {
+		history, err = historyFrames(roomID, c.ID, historyLimit(c))
+	}
+	if err != nil {
+		log.Println(err) // still join, just without history
+	}
+	c.Hub.shardFor(roomID).join <- RoomRequest{Client: c, RoomID: roomID, History: history}
+}
+
+// handles a connected client leaving one of its rooms, the connection stays open even if it leaves every room
+func dispatchLeaveRoom(c *Client, wsMessage *protocol.WebSocketMessage) {
+	membershipData, err := Decode[protocol.RoomMembershipData](wsMessage.Payload)
+	if err != nil || membershipData.RoomID == "" {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid leave room payload, room_id is required.")
+		return
+	}
+	if !c.InRoom(membershipData.RoomID) {
+		sendError(c, wsMessage.ID, protocol.CodeNotJoined, fmt.Sprintf("Not in Room %s.", membershipData.RoomID))
+		return
+	}
+	c.Hub.shardFor(membershipData.RoomID).leave <- RoomRequest{Client: c, RoomID: membershipData.RoomID}
+}
+
+// resolves the room an inbound message is for, sending a not joined error frame if the client isn't in it
+func resolveRoom(c *Client, wsMessage *protocol.WebSocketMessage, roomID string) (string, bool) {
+	roomID, ok := c.roomFor(roomID)
+	if !ok {
+		sendError(c, wsMessage.ID, protocol.CodeNotJoined, "Join the room first.")
+	}
+	return roomID, ok
+}
+
+// handles a client starting or stopping typing, the payload is optional for the initial room
+func dispatchTyping(c *Client, wsMessage *protocol.WebSocketMessage) {
+	var typingData protocol.TypingUpdateData
+	if len(wsMessage.Payload) > 0 && string(wsMessage.Payload) != "null" {
+		data, err := Decode[protocol.TypingUpdateData](wsMessage.Payload)
+		if err != nil {
+			sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid typing payload.")
+			return
+		}
+		typingData = *data
+	}
+	roomID, ok := resolveRoom(c, wsMessage, typingData.RoomID)
+	if !ok {
+		return
+	}
+	c.Hub.shardFor(roomID).typing <- TypingRequest{c, roomID, wsMessage.Type == protocol.TypingStart}
+}
+
+// how many messages of history to send a client joining a room, leaving room in its send buffer for live messages
+func historyLimit(c *Client) int {
+	return min(config.App.Chat.HistoryLimit, cap(c.Send)/2)
+}
+
+// handler for a client joining a room, a client joining another room gets the join confirmation and room
+// history before any live messages from the room
+func (s *roomShard) handleJoinRoom(request RoomRequest) {
+	c := request.Client
+	if c.InRoom(request.RoomID) {
+		return
+	}
+	if !request.Initial {
+		if !s.sendRoomMembership(c, protocol.JoinRoom, request.RoomID, "") {
+			return
+		}
+		for _, data := range request.History {
+			if !c.trySend(data) {
+				return
+			}
+		}
+	}
+	s.addToRoom(c, request.RoomID)
+}
+
+// handler for a client leaving one of its rooms, or being removed from it because it disconnected
+func (s *roomShard) handleLeaveRoom(request RoomRequest) {
+	c := request.Client
+	if _, ok := s.rooms[request.RoomID][c]; !ok {
+		return
+	}
+	s.stopTyping(c, request.RoomID)
+	s.removeFromRoom(c, request.RoomID)
+	if !request.Disconnected {
+		s.sendRoomMembership(c, protocol.LeaveRoom, request.RoomID, "")
+	} else if grace := config.App.Chat.ReconnectGrace; grace > 0 {
+		// the connection may have just dropped, only the user list changes until the grace window runs out
+		log.Printf("%s disconnected from Room %s", c.Username(), request.RoomID)
+		s.pendingLeaves[roomUser{request.RoomID, c.ID}] = pendingLeave{c.Username(), time.Now().Add(grace)}
+		if s.rooms[request.RoomID] != nil {
+			s.broadcastActiveUserList(request.RoomID)
+		}
+		return
+	}
+	s.announceLeave(c.Username(), request.RoomID)
+}
+
+// announces leave notices of users who didn't reconnect within the grace window
+func (s *roomShard) expirePendingLeaves(now time.Time) {
+	for key, pending := range s.pendingLeaves {
+		if now.After(pending.until) {
+			delete(s.pendingLeaves, key)
+			s.announceLeave(pending.username, key.roomID)
+		}
+	}
+}
+
+// adds a client to a room and tells the room it joined, does nothing if the client is disconnecting
+func (s *roomShard) addToRoom(c *Client, roomID string) {
+	if !c.join(roomID) {
+		return
+	}
+	if s.rooms[roomID] == nil { // if room doesn't exist yet create a new one
+		s.rooms[roomID] = make(map[*Client]struct{})
+	}
+	s.rooms[roomID][c] = struct{}{}
+	s.publishMembers(roomID)
+	s.broadcastActiveUserList(roomID)
+
+	// reconnected before its leave was announced, so the room never hears it left
+	key := roomUser{roomID, c.ID}
+	if _, ok := s.pendingLeaves[key]; ok {
+		delete(s.pendingLeaves, key)
+		log.Printf("%s reconnected to Room %s ", c.Username(), roomID)
+		return
+	}
+
+	msg := fmt.Sprintf("%s has joined Room %s ", c.Username(), roomID)
+	s.broadcastNotification(roomID, msg)
+
+	log.Printf("%s has joined Room %s ", c.Username(), roomID)
+}
+
+// removes a client from a room, only other instances are told straight away
+func (s *roomShard) removeFromRoom(c *Client, roomID string) {
+	delete(s.rooms[roomID], c)
+	c.leave(roomID)
+	if len(s.rooms[roomID]) == 0 { // delete room if it's empty
+		delete(s.rooms, roomID)
+		log.Printf("Deleted empty Room %s.", roomID)
+	}
+	s.publishMembers(roomID)
+}
+
+// tells a room a user left it
+func (s *roomShard) announceLeave(username, roomID string) {
+	msg := fmt.Sprintf("%s has left Room %s ", username, roomID)
+	log.Print(msg)
+	if s.rooms[roomID] == nil {
+		return // nobody left to tell on this instance
+	}
+	s.broadcastNotification(roomID, msg)
+	s.broadcastActiveUserList(roomID) // broadcast to the room current active users
+}
+
+// sends a join or leave notification to a room from within its shard, the shard can't queue onto its own channel
+func (s *roomShard) broadcastNotification(roomID, text string) {
+	data, err := EncodeWsMessage(protocol.Chat, notificationData(roomID, text))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s.handleBroadcastChatMessage(ChatMessage{roomID, data, "", text})
+}
+
+// confirms to a client that it joined or left a room, returns false if the client was disconnected instead
+func (s *roomShard) sendRoomMembership(c *Client, messageType protocol.MessageType, roomID, reason string) bool {
+	data, err := EncodeWsMessage(messageType, protocol.RoomMembershipData{RoomID: roomID, Reason: reason})
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return c.trySend(data)
+}
@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// longest ban or mute a moderator can give, longer ones should be permanent
+const maxModerationDuration = 365 * 24 * time.Hour
+
+// handles an inbound kick, ban, unban, mute or unmute from a client against a user in one of the clients rooms
+func dispatchModeration(c *Client, wsMessage *protocol.WebSocketMessage) {
+	moderationData, err := Decode[protocol.ModerationData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid moderation payload.")
+		return
+	}
+	// negative durations would be stored as permanent, and huge ones overflow time.Duration
+	if moderationData.DurationSeconds < 0 || moderationData.DurationSeconds > int(maxModerationDuration.Seconds()) {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, fmt.Sprintf("Duration must be between 0 and %d seconds.", int(maxModerationDuration.Seconds())))
+		return
+	}
+	roomID, ok := resolveRoom(c, wsMessage, moderationData.RoomID)
+	if !ok {
+		return
+	}
+	targetID, targetUsername, err := resolveUser(moderationData.UserID, moderationData.Username)
+	if err != nil {
+		sendResolveError(c, wsMessage.ID, "User", fmt.Errorf("Error resolving moderation target: %w", err))
+		return
+	}
+	if err := rooms.CheckCanModerate(roomID, c.ID, targetID); err != nil {
+		sendModerationError(c, wsMessage.ID, err)
+		return
+	}
+	duration := time.Duration(moderationData.DurationSeconds) * time.Second
+	reason := moderationData.Reason
+
+	var notice string
+	switch wsMessage.Type {
+	case protocol.Kick:
+		c.Hub.shardFor(roomID).kick <- KickRequest{roomID, targetID, withReason("Kicked from the room.", reason)}
+		notice = fmt.Sprintf("%s was kicked by %s", targetUsername, c.Username())
+	case protocol.Ban:
+		if err = rooms.Ban(roomID, targetID, c.ID, reason, duration); err == nil {
+			c.Hub.shardFor(roomID).kick <- KickRequest{roomID, targetID, withReason("Banned from the room.", reason)}
+			notice = fmt.Sprintf("%s was banned by %s%s", targetUsername, c.Username(), forDuration(duration))
+		}
+	case protocol.Unban:
+		if err = rooms.Unban(roomID, targetID); err == nil {
+			notice = fmt.Sprintf("%s was unbanned by %s", targetUsername, c.Username())
+		}
+	case protocol.Mute:
+		if err = rooms.Mute(roomID, targetID, c.ID, duration); err == nil {
+			notice = fmt.Sprintf("%s was muted by %s%s", targetUsername, c.Username(), forDuration(duration))
+		}
+	case protocol.Unmute:
+		if err = rooms.Unmute(roomID, targetID); err == nil {
+			notice = fmt.Sprintf("%s was unmuted by %s", targetUsername, c.Username())
+		}
+	}
+	if err != nil {
+		sendModerationError(c, wsMessage.ID, err)
+		return
+	}
+	log.Printf("(Room %s) %s", roomID, notice)
+	dispatchNotification(c.Hub, roomID, notice)
+}
+
+// sends the error frame for a failed moderation command
+func sendModerationError(c *Client, correlationID string, err error) {
+	if errors.Is(err, rooms.ErrNotModerator) || errors.Is(err, rooms.ErrOutranked) {
+		sendError(c, correlationID, protocol.CodePermissionDenied, err.Error())
+		return
+	}
+	log.Println(err)
+	sendError(c, correlationID, protocol.CodeInternal, "Failed to apply moderation.")
+}
+
+// appends a moderators reason to a close frame message
+func withReason(message, reason string) string {
+	if reason == "" {
+		return message
+	}
+	return fmt.Sprintf("%s Reason: %s", message, reason)
+}
+
+// describes the length of a ban or mute for room notifications
+func forDuration(duration time.Duration) string {
+	if duration <= 0 {
+		return ""
+	}
+	return " for " + duration.String()
+}
@@ -0,0 +1,212 @@
+package chat
+
+import (
+	"chatapp/pkg/protocol"
+	"log"
+	"time"
+)
+
+const (
+	// connected users show as away after this long without sending a message
+	awayAfter = 5 * time.Minute
+	// how often the hub checks for users that went idle
+	presenceSweepInterval = 15 * time.Second
+)
+
+// how present each status is, a user connected to several instances shows their most present status
+var presenceRank = map[protocol.Status]int{protocol.Offline: 0, protocol.Away: 1, protocol.Online: 2, protocol.DoNotDisturb: 3}
+
+// presence state of a user connected to this or another instance, owned by the hub goroutine
+type presence struct {
+	chosen protocol.Status           // Away or DoNotDisturb set by the user, empty to follow activity
+	local  protocol.Status           // status from this instances clients
+	remote map[string]remotePresence // Key: instance ID, status from other instances with clients of the user
+	status protocol.Status           // last status sent to other users
+}
+
+// a users status from another instances clients
+type remotePresence struct {
+	status protocol.Status
+	rooms  []string // rooms the users clients on that instance are in
+}
+
+type StatusRequest struct {
+	Client *Client         // client that set the status
+	Status protocol.Status // Online clears a chosen away or do not disturb status
+}
+
+// handles an inbound status change from a client
+func dispatchSetStatus(c *Client, wsMessage *protocol.WebSocketMessage) {
+	presenceData, err := Decode[protocol.PresenceData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid presence payload.")
+		return
+	}
+	switch presenceData.Status {
+	case protocol.Online, protocol.Away, protocol.DoNotDisturb:
+	default:
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Status must be online, away or dnd.")
+		return
+	}
+	c.Hub.setStatus <- StatusRequest{c, presenceData.Status}
+}
+
+// handler for a user choosing their status
+func (h *Hub) handleSetStatus(request StatusRequest) {
+	c := request.Client
+	p := h.presence[c.ID]
+	if p == nil || !h.isConnected(c) {
+		return // already disconnected
+	}
+	h.chooseStatus(c.ID, p, request.Status)
+	h.publish(brokerEvent{Kind: statusEvent, UserID: c.ID, Status: request.Status})
+}
+
+// sets a connected users chosen status, Online clears it
+func (h *Hub) chooseStatus(userID string, p *presence, status protocol.Status) {
+	p.chosen = status
+	if p.chosen == protocol.Online {
+		p.chosen = ""
+	}
+	h.updatePresence(userID)
+}
+
+// returns a users presence, creating it as offline if they have none
+func (h *Hub) presenceOf(userID string) *presence {
+	p := h.presence[userID]
+	if p == nil {
+		p = &presence{local: protocol.Offline, status: protocol.Offline, remote: make(map[string]remotePresence)}
+		h.presence[userID] = p
+	}
+	return p
+}
+
+// recalculates a users status from this instances clients, publishing it to other instances if it changed
+// rooms are where to send the event if the user has no clients left, otherwise it goes to every room they are in
+func (h *Hub) updatePresence(userID string, rooms ...string) {
+	p := h.presenceOf(userID)
+	if local := h.currentStatus(userID, p); local != p.local {
+		p.local = local
+		h.publish(brokerEvent{Kind: presenceEvent, UserID: userID, Status: local, Rooms: h.userRooms(userID, rooms...)})
+	}
+	h.showPresence(userID, p, rooms...)
+}
+
+// stores a users status from another instance
+func (h *Hub) setRemotePresence(instance, userID string, status protocol.Status, rooms []string) {
+	p := h.presenceOf(userID)
+	if status == protocol.Offline {
+		delete(p.remote, instance)
+	} else {
+		p.remote[instance] = remotePresence{status, rooms}
+	}
+	h.showPresence(userID, p, rooms...)
+}
+
+// queues a presence event if a users status across every instance changed
+// it is sent to rooms and every room the user has a client in on any instance
+func (h *Hub) showPresence(userID string, p *presence, rooms ...string) {
+	status := p.local
+	for _, remote := range p.remote {
+		if presenceRank[remote.status] > presenceRank[status] {
+			status = remote.status
+		}
+	}
+	if status == p.status {
+		if status == protocol.Offline {
+			delete(h.presence, userID)
+		}
+		return
+	}
+	p.status = status
+	h.statusMu.Lock()
+	if status == protocol.Offline {
+		delete(h.statuses, userID)
+	} else {
+		h.statuses[userID] = status
+	}
+	h.statusMu.Unlock()
+	if h.presenceChanged[userID] == nil {
+		h.presenceChanged[userID] = make(map[string]struct{})
+	}
+	for _, roomID := range h.userRooms(userID, rooms...) {
+		h.presenceChanged[userID][roomID] = struct{}{}
+	}
+	for _, remote := range p.remote {
+		for _, roomID := range remote.rooms {
+			h.presenceChanged[userID][roomID] = struct{}{}
+		}
+	}
+}
+
+// rooms plus every room the user has a client of this instance in
+func (h *Hub) userRooms(userID string, rooms ...string) []string {
+	all := append([]string(nil), rooms...)
+	for client := range h.users[userID] {
+		all = append(all, client.joinedRooms()...)
+	}
+	return all
+}
+
+// a users status from their chosen status and how long since any of their clients sent a message
+func (h *Hub) currentStatus(userID string, p *presence) protocol.Status {
+	clients := h.users[userID]
+	if len(clients) == 0 {
+		return protocol.Offline
+	}
+	if p.chosen != "" {
+		return p.chosen
+	}
+	var lastActive time.Time
+	for client := range clients {
+		if active := client.lastActiveTime(); active.After(lastActive) {
+			lastActive = active
+		}
+	}
+	if time.Since(lastActive) > awayAfter {
+		return protocol.Away
+	}
+	return protocol.Online
+}
+
+// the status shown for a user in user lists, safe to call from shards
+func (h *Hub) statusOf(userID string) protocol.Status {
+	h.statusMu.RLock()
+	defer h.statusMu.RUnlock()
+	if status, ok := h.statuses[userID]; ok {
+		return status
+	}
+	return protocol.Offline
+}
+
+// rechecks every connected user for going idle or becoming active again
+func (h *Hub) sweepPresence() {
+	for userID := range h.users {
+		h.updatePresence(userID)
+	}
+}
+
+// sends queued presence events to every client sharing a room with the user, including their own clients
+// called after each hub event, each room gets the event through its shard so clients sharing several rooms with the
+// user can get it more than once
+func (h *Hub) flushPresence() {
+	for userID, rooms := range h.presenceChanged {
+		delete(h.presenceChanged, userID)
+		p := h.presence[userID]
+		if p == nil {
+			continue
+		}
+		data, err := EncodeWsMessage(protocol.Presence, protocol.PresenceData{UserID: userID, Status: p.status})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for roomID := range rooms {
+			h.shardFor(roomID).events <- brokerEvent{Kind: roomEvent, RoomID: roomID, Data: data.JSON}
+		}
+		if p.status == protocol.Offline {
+			delete(h.presence, userID)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"chatapp/pkg/protocol"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// set at build time with -ldflags "-X chatapp/internal/chat.ServerVersion=..."
+var ServerVersion = "dev"
+
+// handles a client saying which protocol version it speaks and which features it wants, answered with a welcome
+// clients on a version this server doesn't speak get an error frame and are disconnected
+func dispatchHello(c *Client, wsMessage *protocol.WebSocketMessage) {
+	helloData, err := Decode[protocol.HelloData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid hello payload.")
+		return
+	}
+	if helloData.ProtocolVersion < protocol.MinProtocolVersion || helloData.ProtocolVersion > protocol.ProtocolVersion {
+		msg := fmt.Sprintf("Protocol version %d isn't supported, this server speaks versions %d to %d.", helloData.ProtocolVersion, protocol.MinProtocolVersion, protocol.ProtocolVersion)
+		sendError(c, wsMessage.ID, protocol.CodeUnsupportedVersion, msg)
+		c.closeSend(protocol.CloseUnsupportedVersion, msg)
+		log.Printf("Disconnected %s for using protocol version %d", c.Username(), helloData.ProtocolVersion)
+		return
+	}
+	enabled := make([]string, 0, len(helloData.Features))
+	for _, feature := range helloData.Features {
+		if _, ok := protocol.FeatureTypes[feature]; ok && !slices.Contains(enabled, feature) {
+			enabled = append(enabled, feature)
+		}
+	}
+	if !c.setFeatures(enabled) {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Hello can only be sent once.")
+		return
+	}
+	log.Printf("%s connected with %q on protocol version %d, features %v", c.Username(), helloData.Client, helloData.ProtocolVersion, enabled)
+	sendWelcome(c, helloData.ProtocolVersion, enabled)
+}
+
+// clients that never said hello get a welcome before their first other message is handled, so they still learn the
+// limits they are held to, with every feature like they are sent
+func welcomeWithoutHello(c *Client) {
+	if !c.welcomed {
+		sendWelcome(c, protocol.MinProtocolVersion, protocol.Features())
+	}
+}
+
+// tells the client who it is, its limits and the features it gets
+func sendWelcome(c *Client, protocolVersion int, enabled []string) {
+	c.welcomed = true
+	data, err := EncodeWsMessage(protocol.Welcome, protocol.WelcomeData{
+		ProtocolVersion: protocolVersion,
+		ServerVersion:   ServerVersion,
+		SessionID:       c.SessionID,
+		UserID:          c.ID,
+		Username:        c.Username(),
+		Limits:          clientLimits(),
+		Features:        enabled,
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.trySend(data)
+}
+
+// enables only the given optional features, returns false if the client already said hello
+func (c *Client) setFeatures(enabled []string) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.skipped != nil {
+		return false
+	}
+	c.skipped = make(map[protocol.MessageType]struct{})
+	for feature, messageType := range protocol.FeatureTypes {
+		if !slices.Contains(enabled, feature) {
+			c.skipped[messageType] = struct{}{}
+		}
+	}
+	return true
+}
+
+// returns true if the frame is for a feature the client didn't ask for, must be called with sendMu held
+func (c *Client) skips(frame *Frame) bool {
+	_, ok := c.skipped[frame.Type]
+	return ok
+}
+
+// random ID telling a connection apart from the users other connections
+func newSessionID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatal("Could not generate session ID: ", err)
+	}
+	return hex.EncodeToString(bytes)
+}
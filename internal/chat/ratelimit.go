@@ -0,0 +1,155 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// a user gets this many times one connections limit shared across all of their connections on this instance
+	userLimitFactor = 3
+	// per user buckets idle this long are full again and get dropped
+	userBucketIdle = time.Minute
+)
+
+// default limits by message type, CHAT_RATE_LIMITS overrides them
+var defaultRateLimits = map[protocol.MessageType]config.RateLimit{
+	protocol.Chat:           {PerSecond: 2, Burst: 5},
+	protocol.DirectMessage:  {PerSecond: 2, Burst: 5},
+	protocol.MessageEdit:    {PerSecond: 1, Burst: 5},
+	protocol.MessageDelete:  {PerSecond: 1, Burst: 5},
+	protocol.React:          {PerSecond: 4, Burst: 10},
+	protocol.TypingStart:    {PerSecond: 2, Burst: 4},
+	protocol.TypingStop:     {PerSecond: 2, Burst: 4},
+	protocol.ReadUpTo:       {PerSecond: 2, Burst: 5},
+	protocol.Presence:       {PerSecond: 1, Burst: 3},
+	protocol.JoinRoom:       {PerSecond: 2, Burst: 10},
+	protocol.LeaveRoom:      {PerSecond: 2, Burst: 10},
+	protocol.UsernameUpdate: {PerSecond: 0.2, Burst: 2},
+}
+
+var (
+	// limit for moderation, unknown and malformed messages
+	fallbackRateLimit = config.RateLimit{PerSecond: 1, Burst: 5}
+	// how often a connection can be rate limited before it is disconnected, 10 in a row then one every 5 seconds
+	abuseLimit = config.RateLimit{PerSecond: 0.2, Burst: 10}
+)
+
+// per user buckets shared by every connection of a user on this instance
+var userBuckets = &userLimiter{buckets: make(map[string]map[protocol.MessageType]*tokenBucket)}
+
+// token bucket rate limiter, the caller handles locking
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refills the bucket for the time since it was last used and takes a token, returns false if it was empty
+func (b *tokenBucket) take(limit config.RateLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type userLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]map[protocol.MessageType]*tokenBucket // Key: UserID
+	swept   time.Time
+}
+
+// takes a token from a users bucket for a message type, dropping idle users buckets at most once per userBucketIdle
+func (l *userLimiter) take(userID string, messageType protocol.MessageType, limit config.RateLimit, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > userBucketIdle {
+		l.sweep(now)
+	}
+	if l.buckets[userID] == nil {
+		l.buckets[userID] = make(map[protocol.MessageType]*tokenBucket)
+	}
+	bucket := l.buckets[userID][messageType]
+	if bucket == nil {
+		bucket = &tokenBucket{}
+		l.buckets[userID][messageType] = bucket
+	}
+	return bucket.take(limit, now)
+}
+
+func (l *userLimiter) sweep(now time.Time) {
+	l.swept = now
+	for userID, buckets := range l.buckets {
+		for messageType, bucket := range buckets {
+			if now.Sub(bucket.last) > userBucketIdle {
+				delete(buckets, messageType)
+			}
+		}
+		if len(buckets) == 0 {
+			delete(l.buckets, userID)
+		}
+	}
+}
+
+// true if a message type has its own bucket rather than sharing the fallback one
+func hasRateLimit(messageType protocol.MessageType) bool {
+	_, configured := config.App.Chat.RateLimits[string(messageType)]
+	_, ok := defaultRateLimits[messageType]
+	return configured || ok
+}
+
+// the limit for one connection sending a message type
+func rateLimitFor(messageType protocol.MessageType) config.RateLimit {
+	if limit, ok := config.App.Chat.RateLimits[string(messageType)]; ok {
+		return limit
+	}
+	if limit, ok := defaultRateLimits[messageType]; ok {
+		return limit
+	}
+	return fallbackRateLimit
+}
+
+// checks an inbound message against the connections and users limits, only called from the read goroutine
+// rejected messages get an error frame, and connections that keep sending after being limited are disconnected
+func allowMessage(c *Client, messageType protocol.MessageType, correlationID string) bool {
+	if !hasRateLimit(messageType) {
+		messageType = "" // unknown and malformed messages, and types without their own limit, share a bucket
+	}
+	limit := rateLimitFor(messageType)
+	now := time.Now()
+	if c.limits[messageType] == nil {
+		c.limits[messageType] = &tokenBucket{}
+	}
+	userLimit := config.RateLimit{PerSecond: limit.PerSecond * userLimitFactor, Burst: limit.Burst * userLimitFactor}
+	if c.limits[messageType].take(limit, now) && userBuckets.take(c.ID, messageType, userLimit, now) {
+		return true
+	}
+	if !c.abuse.take(abuseLimit, now) {
+		if c.closeSend(websocket.ClosePolicyViolation, "Disconnected for sending messages too quickly.") {
+			log.Printf("Disconnected %s for sending messages too quickly", c.Username())
+		}
+		return false
+	}
+	sendError(c, correlationID, protocol.CodeRateLimited, rateLimitMessage(messageType, limit))
+	return false
+}
+
+// tells the sender how fast they can send
+func rateLimitMessage(messageType protocol.MessageType, limit config.RateLimit) string {
+	if messageType == "" {
+		return fmt.Sprintf("Slow down, at most %g messages a second can be sent.", limit.PerSecond)
+	}
+	return fmt.Sprintf("Slow down, at most %g %s messages a second can be sent.", limit.PerSecond, messageType)
+}
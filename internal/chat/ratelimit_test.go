@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucketTake(t *testing.T) {
+	limit := config.RateLimit{PerSecond: 2, Burst: 3}
+	start := time.Unix(1000, 0)
+	type step struct {
+		after time.Duration // since start
+		want  bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"starts full", []step{{0, true}, {0, true}, {0, true}, {0, false}}},
+		{"refills at the rate", []step{{0, true}, {0, true}, {0, true}, {0, false}, {500 * time.Millisecond, true}, {500 * time.Millisecond, false}}},
+		{"partial refills add up", []step{{0, true}, {0, true}, {0, true}, {250 * time.Millisecond, false}, {500 * time.Millisecond, true}}},
+		{"refill is capped at the burst", []step{{0, true}, {time.Hour, true}, {time.Hour, true}, {time.Hour, true}, {time.Hour, false}}},
+		{"rejected takes don't spend tokens", []step{{0, true}, {0, true}, {0, true}, {0, false}, {0, false}, {500 * time.Millisecond, true}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bucket tokenBucket
+			for i, step := range test.steps {
+				if got := bucket.take(limit, start.Add(step.after)); got != step.want {
+					t.Fatalf("take %d at %v got %v, want %v", i, step.after, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitFor(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{RateLimits: map[string]config.RateLimit{
+		"chat": {PerSecond: 10, Burst: 20},
+		"kick": {PerSecond: 3, Burst: 3},
+	}}}
+	tests := []struct {
+		messageType protocol.MessageType
+		want        config.RateLimit
+		wantOwn     bool
+	}{
+		{protocol.Chat, config.RateLimit{PerSecond: 10, Burst: 20}, true}, // configured overrides the default
+		{protocol.React, defaultRateLimits[protocol.React], true},
+		{protocol.Kick, config.RateLimit{PerSecond: 3, Burst: 3}, true}, // configured without a default
+		{protocol.Ban, fallbackRateLimit, false},
+		{"", fallbackRateLimit, false},
+	}
+	for _, test := range tests {
+		if got := rateLimitFor(test.messageType); got != test.want {
+			t.Errorf("rateLimitFor(%q) = %+v, want %+v", test.messageType, got, test.want)
+		}
+		if got := hasRateLimit(test.messageType); got != test.wantOwn {
+			t.Errorf("hasRateLimit(%q) = %v, want %v", test.messageType, got, test.wantOwn)
+		}
+	}
+}
+
+func TestUserLimiter(t *testing.T) {
+	limiter := &userLimiter{buckets: make(map[string]map[protocol.MessageType]*tokenBucket)}
+	limit := config.RateLimit{PerSecond: 1, Burst: 2}
+	now := time.Unix(1000, 0)
+	for i, want := range []bool{true, true, false} {
+		if got := limiter.take("user", protocol.Chat, limit, now); got != want {
+			t.Fatalf("take %d got %v, want %v", i, got, want)
+		}
+	}
+	if !limiter.take("user", protocol.React, limit, now) {
+		t.Error("message types share a bucket")
+	}
+	if !limiter.take("other", protocol.Chat, limit, now) {
+		t.Error("users share a bucket")
+	}
+	// the next take sweeps buckets idle for longer than userBucketIdle
+	limiter.take("active", protocol.Chat, limit, now.Add(2*userBucketIdle))
+	if _, ok := limiter.buckets["user"]; ok {
+		t.Error("idle user buckets weren't dropped")
+	}
+	if _, ok := limiter.buckets["active"]; !ok {
+		t.Error("active user buckets were dropped")
+	}
+}
+
+func TestAllowMessage(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{RateLimits: map[string]config.RateLimit{
+		"chat": {PerSecond: 0.001, Burst: 2}, // doesn't refill during the test
+	}}}
+	tests := []struct {
+		name       string
+		sent       int
+		wantErrors int  // rate_limited error frames
+		wantClosed bool // disconnected for abuse
+	}{
+		{"within the burst", 2, 0, false},
+		{"over the burst is rejected", 5, 3, false},
+		{"limited too often disconnects", 2 + abuseLimit.Burst + 1, abuseLimit.Burst, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewClient("allow-"+test.name, "user", "", nil, nil)
+			allowed := 0
+			for range test.sent {
+				if allowMessage(c, protocol.Chat, "") {
+					allowed++
+				}
+			}
+			if allowed != min(test.sent, 2) {
+				t.Errorf("allowed %d of %d messages", allowed, test.sent)
+			}
+			errors := 0
+			for _, frame := range queued(c) {
+				if findPayload[protocol.ErrorData](t, []*Frame{frame}, protocol.Error).Code == protocol.CodeRateLimited {
+					errors++
+				}
+			}
+			if errors != test.wantErrors {
+				t.Errorf("got %d rate limit errors, want %d", errors, test.wantErrors)
+			}
+			if c.sendClosed != test.wantClosed {
+				t.Errorf("got disconnected %v, want %v", c.sendClosed, test.wantClosed)
+			}
+			if test.wantClosed && c.closeCode != websocket.ClosePolicyViolation {
+				t.Errorf("got close code %d", c.closeCode)
+			}
+		})
+	}
+}
+
+func TestAllowMessageSharesUserLimitAcrossConnections(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{RateLimits: map[string]config.RateLimit{
+		"chat": {PerSecond: 0.001, Burst: 1},
+	}}}
+	allowed := 0
+	for range userLimitFactor + 2 { // each connection is within its own limit
+		if allowMessage(NewClient("shared-user", "user", "", nil, nil), protocol.Chat, "") {
+			allowed++
+		}
+	}
+	if allowed != userLimitFactor {
+		t.Errorf("allowed %d messages across connections, want %d", allowed, userLimitFactor)
+	}
+}
+
+func TestAllowMessageFallbackBucket(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{}}
+	c := NewClient("fallback-user", "user", "", nil, nil)
+	// types without their own limit share one bucket
+	for i := range fallbackRateLimit.Burst {
+		messageType := []protocol.MessageType{protocol.Kick, protocol.Ban, "unknown"}[i%3]
+		if !allowMessage(c, messageType, "") {
+			t.Fatalf("message %d was limited", i)
+		}
+	}
+	if allowMessage(c, protocol.Mute, "") {
+		t.Error("fallback bucket wasn't shared")
+	}
+	if !allowMessage(c, protocol.Chat, "") {
+		t.Error("chat shares the fallback bucket")
+	}
+}
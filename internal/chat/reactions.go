@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"chatapp/internal/postgres"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"unicode"
+	"unicode/utf8"
+)
+
+// longest reaction accepted in runes, enough for emoji joined with skin tones and zero width joiners
+const maxEmojiLength = 16
+
+// handles an inbound reaction toggle on a message in one of the clients rooms
+func dispatchReact(c *Client, wsMessage *protocol.WebSocketMessage) {
+	reactData, err := Decode[protocol.ReactData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid reaction payload.")
+		return
+	}
+	if !isEmoji(reactData.Emoji) {
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Reactions must be a single emoji.")
+		return
+	}
+	roomID, ok := resolveRoom(c, wsMessage, reactData.RoomID)
+	if !ok {
+		return
+	}
+	message, err := getRoomMessage(roomID, reactData.MessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendError(c, wsMessage.ID, protocol.CodeNotFound, "Message not found.")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to react to message.")
+		return
+	}
+	// muted users can't react either
+	if err := rooms.CheckCanChat(roomID, c.ID); err != nil {
+		if errors.Is(err, rooms.ErrMuted) {
+			sendError(c, wsMessage.ID, protocol.CodeMuted, err.Error())
+		} else {
+			log.Println(err)
+			sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to react to message.")
+		}
+		return
+	}
+
+	added, err := postgres.ToggleReaction(message.ID, c.ID, reactData.Emoji)
+	if err != nil {
+		log.Printf("Error toggling reaction on message %s: %v", message.ID, err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to react to message.")
+		return
+	}
+	reactions, err := loadReactions([]string{message.ID}, "")
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to react to message.")
+		return
+	}
+	dispatchReactionUpdate(c.Hub, protocol.ReactionUpdateData{
+		MessageID: message.ID,
+		RoomID:    roomID,
+		UserID:    c.ID,
+		Emoji:     reactData.Emoji,
+		Added:     added,
+		Reactions: reactions[message.ID],
+	}, c.Username())
+}
+
+// enqueues a reaction update to the rooms shard to get sent to the room
+func dispatchReactionUpdate(hub *Hub, update protocol.ReactionUpdateData, username string) {
+	if update.Reactions == nil {
+		update.Reactions = []protocol.Reaction{} // the last reaction was removed
+	}
+	data, err := EncodeWsMessage(protocol.ReactionUpdate, update)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	action := "removed"
+	if update.Added {
+		action = "added"
+	}
+	logText := fmt.Sprintf("[%s %s %s on %s]", protocol.ReactionUpdate, action, update.Emoji, update.MessageID)
+	hub.sendToRoom(ChatMessage{update.RoomID, data, username, logText})
+}
+
+// sets the reactions on stored messages, marking the ones userID reacted with
+func attachReactions(messages []protocol.ChatMessageData, userID string) error {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		if !message.Deleted {
+			ids = append(ids, message.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	reactions, err := loadReactions(ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].MessageID]
+	}
+	return nil
+}
+
+// loads the reactions on messages grouped by message ID, marking the ones userID reacted with
+func loadReactions(messageIDs []string, userID string) (map[string][]protocol.Reaction, error) {
+	rows, err := postgres.GetReactions(messageIDs, userID)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading reactions: %w", err)
+	}
+	reactions := make(map[string][]protocol.Reaction)
+	for _, row := range rows {
+		reactions[row.MessageID] = append(reactions[row.MessageID], protocol.Reaction{
+			Emoji:   row.Emoji,
+			Count:   row.Count,
+			Reacted: row.Reacted,
+		})
+	}
+	return reactions, nil
+}
+
+// a reaction is a short run of printable runes containing at least one symbol, such as 👍 or 🏳️‍🌈
+// this keeps words out without having to track every emoji sequence unicode defines
+func isEmoji(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	length := utf8.RuneCountInString(s)
+	if length == 0 || length > maxEmojiLength {
+		return false
+	}
+	hasSymbol := false
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r), unicode.IsSpace(r), unicode.IsControl(r):
+			return false
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Me, r): // Me covers keycaps like 1️⃣
+			hasSymbol = true
+		}
+	}
+	return hasSymbol
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"chatapp/internal/postgres"
+	"chatapp/pkg/protocol"
+	"fmt"
+	"log"
+)
+
+// handles a client telling the hub how far it has read in one of its rooms
+// read positions only move forward, older or unknown messages are ignored without an error
+func dispatchReadUpTo(c *Client, wsMessage *protocol.WebSocketMessage) {
+	readData, err := Decode[protocol.ReadUpToData](wsMessage.Payload)
+	if err != nil {
+		log.Println(err)
+		sendError(c, wsMessage.ID, protocol.CodeBadPayload, "Invalid read payload.")
+		return
+	}
+	roomID, ok := resolveRoom(c, wsMessage, readData.RoomID)
+	if !ok {
+		return
+	}
+	if !protocol.IsID(readData.MessageID) {
+		sendError(c, wsMessage.ID, protocol.CodeNotFound, "Message not found.")
+		return
+	}
+	advanced, readAt, err := postgres.SetReadUpTo(roomID, c.ID, readData.MessageID)
+	if err != nil {
+		log.Printf("Error saving read position of %s in Room %s: %v", c.Username(), roomID, err)
+		sendError(c, wsMessage.ID, protocol.CodeInternal, "Failed to mark messages read.")
+		return
+	}
+	if !advanced {
+		return
+	}
+	dispatchReadReceipt(c.Hub, protocol.ReadReceiptData{
+		RoomID:    roomID,
+		UserID:    c.ID,
+		Username:  c.Username(),
+		MessageID: readData.MessageID,
+		ReadAt:    readAt,
+	})
+}
+
+// enqueues a read receipt to the rooms shard to get sent to the room
+func dispatchReadReceipt(hub *Hub, receipt protocol.ReadReceiptData) {
+	data, err := EncodeWsMessage(protocol.ReadReceipt, receipt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	hub.sendToRoom(ChatMessage{receipt.RoomID, data, receipt.Username, fmt.Sprintf("[%s %s]", protocol.ReadReceipt, receipt.MessageID)})
+}
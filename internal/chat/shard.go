@@ -0,0 +1,258 @@
+package chat
+
+import (
+	"chatapp/pkg/protocol"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// clients stop showing as typing this long after their last typing_start
+	typingTimeout = 5 * time.Second
+	// how often each shard checks for typing states that have timed out and leave notices that are due
+	typingSweepInterval = time.Second
+)
+
+// worker goroutine owning a subset of the hubs rooms, everything about a room is handled by its shard
+// shards only send to clients and the broker, never to the hub goroutine or another shard
+type roomShard struct {
+	hub *Hub
+
+	// hashmap of Key:RoomID, Value: hashset of pointers to clients
+	rooms map[string]map[*Client]struct{}
+
+	// inbound messages from peers, unbuffered for backpressure
+	// don't want a single client taking up a buffered channel with spam messages
+	broadcast chan ChatMessage
+
+	join chan RoomRequest // adds a client to a room
+
+	leave chan RoomRequest // removes a client from a room
+
+	kick chan KickRequest // removes a users clients from a room
+
+	typing chan TypingRequest // starts or stops a clients typing indicator
+
+	refresh chan string // resends a rooms user list after a member changed their username
+
+	events chan brokerEvent // room events from other instances and presence frames from the hub goroutine
+
+	// hashmap of Key:client and room it is typing in, Value: when its typing indicator times out
+	typingUntil map[typingKey]time.Time
+
+	// hashmap of Key:RoomID, Value: hashmap of Key:instance ID, Value: that instances clients in the room
+	remoteRooms map[string]map[string][]protocol.UserItem
+
+	// hashmap of Key:room and user who disconnected from it, Value: their leave notice held back in case they reconnect
+	pendingLeaves map[roomUser]pendingLeave
+}
+
+type TypingRequest struct {
+	Client *Client // client that started or stopped typing
+	RoomID string  // room the client is typing in
+	Typing bool    // false when the client stopped typing
+}
+
+// a client typing in a room, clients in several rooms can be typing in more than one
+type typingKey struct {
+	client *Client
+	roomID string
+}
+
+// a user in a room
+type roomUser struct {
+	roomID string
+	userID string
+}
+
+// a leave notice announced once the reconnect grace window runs out
+type pendingLeave struct {
+	username string
+	until    time.Time
+}
+
+func newRoomShard(h *Hub) *roomShard {
+	return &roomShard{
+		hub:           h,
+		rooms:         make(map[string]map[*Client]struct{}),
+		broadcast:     make(chan ChatMessage),
+		join:          make(chan RoomRequest),
+		leave:         make(chan RoomRequest),
+		kick:          make(chan KickRequest),
+		typing:        make(chan TypingRequest),
+		refresh:       make(chan string),
+		events:        make(chan brokerEvent),
+		typingUntil:   make(map[typingKey]time.Time),
+		remoteRooms:   make(map[string]map[string][]protocol.UserItem),
+		pendingLeaves: make(map[roomUser]pendingLeave),
+	}
+}
+
+// manage the shards rooms and broadcasting messages to them
+func (s *roomShard) run() {
+	sweepTicker := time.NewTicker(typingSweepInterval)
+	defer sweepTicker.Stop()
+	for {
+		select {
+		case request := <-s.join:
+			s.handleJoinRoom(request)
+		case request := <-s.leave:
+			s.handleLeaveRoom(request)
+		case chatMessage := <-s.broadcast:
+			s.handleBroadcastChatMessage(chatMessage)
+		case kick := <-s.kick:
+			s.handleKick(kick)
+		case typing := <-s.typing:
+			s.handleTyping(typing)
+		case now := <-sweepTicker.C:
+			s.expireTyping(now)
+			s.expirePendingLeaves(now)
+		case roomID := <-s.refresh:
+			if s.rooms[roomID] != nil {
+				s.publishMembers(roomID)
+				s.broadcastActiveUserList(roomID)
+			}
+		case event := <-s.events:
+			s.handleEvent(event)
+		}
+	}
+}
+
+// handler for broadcasting chat messages
+func (s *roomShard) handleBroadcastChatMessage(message ChatMessage) {
+	log.Printf("(Room %s) %s: %s", message.RoomID, message.SenderUsername, message.MessageText)
+	s.broadcastData(message.RoomID, message.Data)
+	s.hub.publish(brokerEvent{Kind: roomEvent, RoomID: message.RoomID, Data: message.Data.JSON})
+}
+
+// handler for removing a users clients from a room with a reason on every instance
+func (s *roomShard) handleKick(kick KickRequest) {
+	s.kickLocal(kick)
+	s.hub.publish(brokerEvent{Kind: kickEvent, RoomID: kick.RoomID, UserID: kick.UserID, Reason: kick.Reason})
+}
+
+// removes a users clients of this instance from a room
+// clients that were only in that room are disconnected with the reason in the close frame like before they
+// could join several rooms, the rest are told they left the room and stay connected
+func (s *roomShard) kickLocal(kick KickRequest) {
+	kicked := 0
+	for client := range s.rooms[kick.RoomID] {
+		if client.ID != kick.UserID {
+			continue
+		}
+		s.stopTyping(client, kick.RoomID)
+		s.removeFromRoom(client, kick.RoomID)
+		if len(client.joinedRooms()) == 0 {
+			client.closeSend(websocket.ClosePolicyViolation, kick.Reason)
+		} else {
+			s.sendRoomMembership(client, protocol.LeaveRoom, kick.RoomID, kick.Reason)
+		}
+		kicked++
+	}
+	if kicked == 0 {
+		return
+	}
+	log.Printf("Kicked %d clients of %s from Room %s: %s", kicked, kick.UserID, kick.RoomID, kick.Reason)
+	if s.rooms[kick.RoomID] != nil {
+		s.broadcastActiveUserList(kick.RoomID)
+	}
+}
+
+// handler for a client starting or stopping typing, only changes in typing state are broadcast
+// repeated typing_start messages just push back when the indicator times out
+func (s *roomShard) handleTyping(request TypingRequest) {
+	c := request.Client
+	if _, ok := s.rooms[request.RoomID][c]; !ok {
+		return
+	}
+	if !request.Typing {
+		s.stopTyping(c, request.RoomID)
+		return
+	}
+	key := typingKey{c, request.RoomID}
+	_, wasTyping := s.typingUntil[key]
+	s.typingUntil[key] = time.Now().Add(typingTimeout)
+	if !wasTyping {
+		s.broadcastTyping(c, request.RoomID, true)
+	}
+}
+
+// stops typing indicators that weren't refreshed in time
+func (s *roomShard) expireTyping(now time.Time) {
+	for key, until := range s.typingUntil {
+		if now.After(until) {
+			s.stopTyping(key.client, key.roomID)
+		}
+	}
+}
+
+// clears a clients typing state in a room and tells the rest of the room, does nothing if it wasn't typing
+func (s *roomShard) stopTyping(c *Client, roomID string) {
+	key := typingKey{c, roomID}
+	if _, ok := s.typingUntil[key]; !ok {
+		return
+	}
+	delete(s.typingUntil, key)
+	s.broadcastTyping(c, roomID, false)
+}
+
+// sends a clients typing state to everyone else in the room
+func (s *roomShard) broadcastTyping(c *Client, roomID string, typing bool) {
+	data, err := EncodeWsMessage(protocol.Typing, protocol.TypingData{UserID: c.ID, Username: c.Username(), RoomID: roomID, Typing: typing})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for client := range s.rooms[roomID] {
+		if client != c {
+			client.trySend(data)
+		}
+	}
+	s.hub.publish(brokerEvent{Kind: roomEvent, RoomID: roomID, Data: data.JSON})
+}
+
+// sends updated list of active users in a room, including clients connected to other instances
+// executes whenever a new client connects, disconnects, or changes name
+func (s *roomShard) broadcastActiveUserList(RoomID string) {
+	if s.rooms[RoomID] == nil {
+		log.Println("Tried to broadcast to empty room")
+		return
+	}
+	users := append(s.localUsers(RoomID), s.remoteUsers(RoomID)...)
+	for i := range users {
+		users[i].Status = s.hub.statusOf(users[i].ID)
+	}
+
+	data, err := EncodeWsMessage(protocol.UserList, protocol.UserListMessage{RoomID: RoomID, Users: users})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	// broadcast active user list to all clients, slow clients may get only the newest one
+	for client := range s.rooms[RoomID] {
+		client.trySendUserList(RoomID, data)
+	}
+}
+
+// this instances clients in a room
+func (s *roomShard) localUsers(roomID string) []protocol.UserItem {
+	var users []protocol.UserItem
+	for client := range s.rooms[roomID] {
+		users = append(users, protocol.UserItem{ID: client.ID, Username: client.Username()})
+	}
+	return users
+}
+
+// broadcasts an encoded WebSocketMessage to all clients in the room
+func (s *roomShard) broadcastData(RoomID string, frame *Frame) {
+	room := s.rooms[RoomID]
+	if room == nil {
+		log.Println("Tried to broadcast to empty room")
+		return
+	}
+	for client := range room { // push data to all clients send buffered channels
+		client.trySend(frame)
+	}
+}
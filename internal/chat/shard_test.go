@@ -0,0 +1,258 @@
+package chat
+
+import (
+	"chatapp/internal/broker"
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// a hub whose shards are driven directly by the test instead of their goroutines
+func newTestHub(t *testing.T, shards int) *Hub {
+	t.Helper()
+	config.App = &config.Config{Chat: &config.ChatConfig{}}
+	hub := NewHub(broker.NewMemory().Connect(), shards)
+	t.Cleanup(func() { hub.broker.Close() })
+	return hub
+}
+
+// the frames queued on a clients Send buffer so far
+func queued(c *Client) []*Frame {
+	var frames []*Frame
+	for {
+		select {
+		case frame, ok := <-c.Send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, frame)
+		default:
+			return frames
+		}
+	}
+}
+
+// the payload of the first queued frame of a type, fails the test if there is none
+func findPayload[T any](t *testing.T, frames []*Frame, messageType protocol.MessageType) *T {
+	t.Helper()
+	for _, frame := range frames {
+		if frame.Type != messageType {
+			continue
+		}
+		var wsMessage struct{ Payload T }
+		if err := json.Unmarshal(frame.JSON, &wsMessage); err != nil {
+			t.Fatal(err)
+		}
+		return &wsMessage.Payload
+	}
+	t.Fatalf("no %s frame in %d queued frames", messageType, len(frames))
+	return nil
+}
+
+func TestShardForIsStable(t *testing.T) {
+	for _, shards := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			hub, other := newTestHub(t, shards), newTestHub(t, shards)
+			used := make(map[*roomShard]struct{})
+			for i := range 1000 {
+				roomID := fmt.Sprintf("room-%d", i)
+				shard := hub.shardFor(roomID)
+				if hub.shardFor(roomID) != shard {
+					t.Fatalf("%s moved shards between calls", roomID)
+				}
+				// the same room must land on the same shard index on every instance
+				if index(hub.shards, shard) != index(other.shards, other.shardFor(roomID)) {
+					t.Fatalf("%s is on different shards of two hubs", roomID)
+				}
+				used[shard] = struct{}{}
+			}
+			if len(used) != shards {
+				t.Errorf("1000 rooms used %d of %d shards", len(used), shards)
+			}
+		})
+	}
+}
+
+func index(shards []*roomShard, shard *roomShard) int {
+	for i := range shards {
+		if shards[i] == shard {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestNewHubHasAtLeastOneShard(t *testing.T) {
+	hub := newTestHub(t, 0)
+	if len(hub.shards) != 1 || hub.shardFor("room") != hub.shards[0] {
+		t.Fatalf("got %d shards, want 1", len(hub.shards))
+	}
+}
+
+func TestKick(t *testing.T) {
+	tests := []struct {
+		name          string
+		otherRoom     bool // the kicked client is also in another room
+		wantConnected bool
+	}{
+		{"only room disconnects", false, false},
+		{"other rooms stay joined", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := newTestHub(t, 1)
+			shard := hub.shards[0]
+			target := NewClient("target", "target", "room", hub, nil)
+			bystander := NewClient("bystander", "bystander", "room", hub, nil)
+			shard.handleJoinRoom(RoomRequest{Client: target, RoomID: "room", Initial: true})
+			shard.handleJoinRoom(RoomRequest{Client: bystander, RoomID: "room", Initial: true})
+			if test.otherRoom {
+				shard.handleJoinRoom(RoomRequest{Client: target, RoomID: "other"})
+			}
+			queued(target)
+			queued(bystander)
+
+			shard.handleKick(KickRequest{RoomID: "room", UserID: "target", Reason: "Kicked from the room."})
+
+			if target.InRoom("room") {
+				t.Error("target is still in the room")
+			}
+			if _, ok := shard.rooms["room"][target]; ok {
+				t.Error("shard still lists target in the room")
+			}
+			frames := queued(target)
+			if test.wantConnected {
+				if target.sendClosed {
+					t.Fatal("target was disconnected")
+				}
+				leave := findPayload[protocol.RoomMembershipData](t, frames, protocol.LeaveRoom)
+				if leave.RoomID != "room" || leave.Reason != "Kicked from the room." {
+					t.Errorf("got leave %+v", leave)
+				}
+				if !target.InRoom("other") {
+					t.Error("target left its other room")
+				}
+			} else {
+				if !target.sendClosed {
+					t.Fatal("target wasn't disconnected")
+				}
+				if target.closeCode != websocket.ClosePolicyViolation || target.closeReason != "Kicked from the room." {
+					t.Errorf("got close %d %q", target.closeCode, target.closeReason)
+				}
+			}
+			users := findPayload[protocol.UserListMessage](t, queued(bystander), protocol.UserList)
+			if len(users.Users) != 1 || users.Users[0].ID != "bystander" {
+				t.Errorf("bystander got user list %+v", users.Users)
+			}
+		})
+	}
+}
+
+func TestKickOnlyRemovesTheTarget(t *testing.T) {
+	hub := newTestHub(t, 1)
+	shard := hub.shards[0]
+	c := NewClient("user", "user", "room", hub, nil)
+	shard.handleJoinRoom(RoomRequest{Client: c, RoomID: "room", Initial: true})
+	shard.handleKick(KickRequest{RoomID: "room", UserID: "someone-else", Reason: "Kicked."})
+	if !c.InRoom("room") || c.sendClosed {
+		t.Fatal("kicking another user removed the client")
+	}
+}
+
+func TestLeaveRoom(t *testing.T) {
+	tests := []struct {
+		name         string
+		disconnected bool
+		grace        time.Duration
+		wantConfirm  bool // the leaving client is sent a leave_room confirmation
+		wantPending  bool // the leave notice is held back for the grace window
+	}{
+		{"leave is confirmed", false, time.Minute, true, false},
+		{"disconnect is held back", true, time.Minute, false, true},
+		{"disconnect without grace is announced", true, 0, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := newTestHub(t, 1)
+			config.App.Chat.ReconnectGrace = test.grace
+			shard := hub.shards[0]
+			leaving := NewClient("leaving", "leaving", "room", hub, nil)
+			staying := NewClient("staying", "staying", "room", hub, nil)
+			shard.handleJoinRoom(RoomRequest{Client: leaving, RoomID: "room", Initial: true})
+			shard.handleJoinRoom(RoomRequest{Client: staying, RoomID: "room", Initial: true})
+			queued(leaving)
+			queued(staying)
+
+			shard.handleLeaveRoom(RoomRequest{Client: leaving, RoomID: "room", Disconnected: test.disconnected})
+
+			if leaving.InRoom("room") {
+				t.Error("client is still in the room")
+			}
+			confirmed := false
+			for _, frame := range queued(leaving) {
+				confirmed = confirmed || frame.Type == protocol.LeaveRoom
+			}
+			if confirmed != test.wantConfirm {
+				t.Errorf("got leave confirmation %v, want %v", confirmed, test.wantConfirm)
+			}
+			_, pending := shard.pendingLeaves[roomUser{"room", "leaving"}]
+			if pending != test.wantPending {
+				t.Errorf("got pending leave %v, want %v", pending, test.wantPending)
+			}
+			notices := 0
+			for _, frame := range queued(staying) {
+				if frame.Type == protocol.Chat {
+					notices++
+				}
+			}
+			wantNotices := 1
+			if test.wantPending {
+				wantNotices = 0
+			}
+			if notices != wantNotices {
+				t.Errorf("room got %d leave notices, want %d", notices, wantNotices)
+			}
+		})
+	}
+}
+
+func TestRejoinWithinGraceIsNotAnnounced(t *testing.T) {
+	hub := newTestHub(t, 1)
+	config.App.Chat.ReconnectGrace = time.Minute
+	shard := hub.shards[0]
+	first := NewClient("user", "user", "room", hub, nil)
+	staying := NewClient("staying", "staying", "room", hub, nil)
+	shard.handleJoinRoom(RoomRequest{Client: staying, RoomID: "room", Initial: true})
+	shard.handleJoinRoom(RoomRequest{Client: first, RoomID: "room", Initial: true})
+	shard.handleLeaveRoom(RoomRequest{Client: first, RoomID: "room", Disconnected: true})
+	queued(staying)
+
+	reconnected := NewClient("user", "user", "room", hub, nil)
+	shard.handleJoinRoom(RoomRequest{Client: reconnected, RoomID: "room", Initial: true})
+	shard.expirePendingLeaves(time.Now().Add(2 * time.Minute))
+
+	if len(shard.pendingLeaves) != 0 {
+		t.Errorf("%d leave notices still pending", len(shard.pendingLeaves))
+	}
+	for _, frame := range queued(staying) {
+		if frame.Type == protocol.Chat {
+			t.Errorf("room was sent a notice: %s", frame.JSON)
+		}
+	}
+}
+
+func TestEmptyRoomIsDeleted(t *testing.T) {
+	hub := newTestHub(t, 1)
+	shard := hub.shards[0]
+	c := NewClient("user", "user", "room", hub, nil)
+	shard.handleJoinRoom(RoomRequest{Client: c, RoomID: "room", Initial: true})
+	shard.handleLeaveRoom(RoomRequest{Client: c, RoomID: "room"})
+	if _, ok := shard.rooms["room"]; ok {
+		t.Error("empty room wasn't deleted")
+	}
+}
@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// close frame reason sent with the service restart code when the server shuts down
+const restartReason = "Server restarting, reconnect in a few seconds."
+
+// returns false once the hub started shutting down, new connections should be refused
+func (h *Hub) Accepting() bool {
+	return !h.closing.Load()
+}
+
+// reads and writes a registered clients websocket frames on separate threads until it disconnects
+// Shutdown waits for both so queued messages are written and in-flight messages are saved
+// a client that got past Accepting just as shutdown started is refused with a service restart close frame instead
+func (h *Hub) Serve(c *Client) {
+	h.connMu.Lock()
+	if h.closing.Load() {
+		h.connMu.Unlock()
+		c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, restartReason))
+		c.Conn.Close()
+		h.UnregisterClient(c)
+		return
+	}
+	h.connections.Add(2)
+	h.connMu.Unlock()
+	go func() {
+		defer h.connections.Done()
+		c.ReceiveWsMessage()
+	}()
+	go func() {
+		defer h.connections.Done()
+		c.SendWsMessage()
+	}()
+}
+
+// runs a database write on its own goroutine that Shutdown waits for
+// only called from a connections goroutines, so the WaitGroup is never empty while Shutdown waits on it
+func (h *Hub) persist(write func()) {
+	h.connections.Add(1)
+	go func() {
+		defer h.connections.Done()
+		write()
+	}()
+}
+
+// stops accepting clients and closes every connection with a service restart close frame once its queued messages
+// are written, then waits for messages the clients already sent to be saved
+// returns ctx.Err() if ctx ends first, leaving the remaining connections to be dropped when the process exits
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.connMu.Lock()
+	alreadyClosing := h.closing.Swap(true)
+	h.connMu.Unlock()
+	if alreadyClosing {
+		return nil
+	}
+	h.shutdown <- struct{}{}
+	done := make(chan struct{})
+	go func() {
+		h.connections.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handler for the server shutting down, each clients write goroutine flushes Send before sending the close frame
+func (h *Hub) handleShutdown() {
+	closed := 0
+	for _, clients := range h.users {
+		for client := range clients {
+			if client.closeSend(websocket.CloseServiceRestart, restartReason) {
+				closed++
+			}
+		}
+	}
+	log.Printf("Closing %d connections for shutdown", closed)
+}
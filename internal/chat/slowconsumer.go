@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"expvar"
+	"log"
+)
+
+// what happens when a frame is sent to a client whose send buffer is full, set by CHAT_SLOW_CONSUMER_POLICY
+const (
+	// close the connection with CloseSlowConsumer, the client is expected to reconnect and resume
+	DisconnectSlowConsumers = "disconnect"
+	// drop the oldest queued frame to make room, the client may miss messages but stays connected
+	DropOldest = "drop_oldest"
+	// keep only the newest user list of each room until the buffer drains, any other frame disconnects the client
+	CoalesceUserLists = "coalesce"
+)
+
+const slowConsumerReason = "Disconnected for not keeping up with messages, reconnect to resume."
+
+// counts of frames slow clients didn't get sent straight away, served at /debug/vars when enabled
+var slowConsumerMetrics = expvar.NewMap("chat_slow_consumers")
+
+// handles a frame that doesn't fit in a full Send buffer, must be called with sendMu held
+// returns false if the client was disconnected instead
+func (c *Client) sendFull(frame *Frame) bool {
+	if config.App.Chat.SlowConsumerPolicy == DropOldest {
+		select {
+		case <-c.Send:
+			slowConsumerMetrics.Add("dropped_frames", 1)
+		default: // the write goroutine just took it
+		}
+		select {
+		case c.Send <- frame:
+			return true
+		default:
+		}
+	}
+	slowConsumerMetrics.Add("disconnects", 1)
+	log.Printf("Disconnecting %s for not keeping up with messages", c.Username())
+	c.setCloseReason(protocol.CloseSlowConsumer, slowConsumerReason)
+	c.sendClosed = true
+	close(c.Send)
+	return false
+}
+
+// queues a rooms user list, with the coalesce policy a full buffer keeps it aside in place of any older one for
+// the room, the write goroutine sends it once everything queued before it was written
+func (c *Client) trySendUserList(roomID string, frame *Frame) bool {
+	if config.App.Chat.SlowConsumerPolicy != CoalesceUserLists {
+		return c.trySend(frame)
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return false
+	}
+	if c.skips(frame) {
+		return true
+	}
+	// an older user list for the room is still waiting, queuing this one would let the older one be written after it
+	if _, ok := c.coalesced[roomID]; ok {
+		c.coalesced[roomID] = frame
+		slowConsumerMetrics.Add("coalesced_user_lists", 1)
+		return true
+	}
+	select {
+	case c.Send <- frame:
+	default:
+		c.coalesced[roomID] = frame
+		slowConsumerMetrics.Add("coalesced_user_lists", 1)
+	}
+	return true
+}
+
+// takes the user lists kept aside while Send was full
+func (c *Client) takeCoalesced() []*Frame {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if len(c.coalesced) == 0 {
+		return nil
+	}
+	frames := make([]*Frame, 0, len(c.coalesced))
+	for roomID, frame := range c.coalesced {
+		frames = append(frames, frame)
+		delete(c.coalesced, roomID)
+	}
+	return frames
+}
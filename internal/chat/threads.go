@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"chatapp/internal/postgres"
+	"chatapp/pkg/protocol"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMessageNotFound = errors.New("Message not found.")
+	ErrNestedReply     = errors.New("Replies can't be replied to, reply to the message that started the thread.")
+)
+
+// a message and every reply to it, returned by the REST thread endpoint
+type Thread struct {
+	Parent  protocol.ChatMessageData   `json:"parent"`
+	Replies []protocol.ChatMessageData `json:"replies"` // ordered oldest to newest
+}
+
+// returns ErrMessageNotFound unless parentID is a message in the room that can start a thread
+func checkReplyParent(roomID, parentID string) error {
+	parent, err := getRoomMessage(roomID, parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if parent.ParentID != "" {
+		return ErrNestedReply
+	}
+	return nil
+}
+
+// loads the reply count and last reply time of a thread
+func loadThreadSummary(parentID string) (*protocol.ThreadSummary, error) {
+	parent, err := postgres.GetMessage(parentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading thread %s: %w", parentID, err)
+	}
+	summary := &protocol.ThreadSummary{ParentMessageID: parent.ID, ReplyCount: parent.ReplyCount}
+	if parent.LastReplyAt.Valid {
+		summary.LastReplyAt = parent.LastReplyAt.Time
+	}
+	return summary, nil
+}
+
+// loads a message in a room with all of its replies, reactions are marked with whether userID reacted
+// returns ErrMessageNotFound if the message isn't in the room or is itself a reply
+func LoadThread(roomID, userID, parentID string) (*Thread, error) {
+	if !protocol.IsID(parentID) {
+		return nil, ErrMessageNotFound
+	}
+	parent, err := postgres.GetMessage(parentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error loading thread %s: %w", parentID, err)
+	}
+	if parent.RoomID != roomID || parent.ParentID != "" {
+		return nil, ErrMessageNotFound
+	}
+	rows, err := postgres.GetThreadReplies(parentID)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading replies to %s: %w", parentID, err)
+	}
+	messages := make([]protocol.ChatMessageData, 0, len(rows)+1)
+	messages = append(messages, chatMessageFromRow(parent))
+	for _, row := range rows {
+		messages = append(messages, chatMessageFromRow(row))
+	}
+	if err := attachReactions(messages, userID); err != nil {
+		return nil, fmt.Errorf("Error loading reactions in thread %s: %w", parentID, err)
+	}
+	return &Thread{Parent: messages[0], Replies: messages[1:]}, nil
+}
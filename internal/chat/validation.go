@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrEmptyText        = errors.New("Message text can't be empty.")
+	ErrControlCharacter = errors.New("Message text can't contain control characters.")
+)
+
+// peers sending a single message this many times over the frame size limit are disconnected instead of being sent
+// an error frame, the read limit is never below maxReadSize
+const readLimitFactor = 4
+
+// largest inbound message accepted in bytes, larger messages are rejected with an error frame
+func maxFrameSize() int {
+	return config.App.Chat.MaxFrameBytes
+}
+
+// longest message text accepted in runes
+func maxTextLength() int {
+	return config.App.Chat.MaxTextRunes
+}
+
+// hard limit on the size of a message read from the connection
+func readLimit() int64 {
+	return max(maxReadSize, int64(maxFrameSize())*readLimitFactor)
+}
+
+// checks the text of an inbound chat, direct or edited message
+// text can't be blank, longer than the rune limit, or contain control characters other than newlines and tabs
+func validateText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrEmptyText
+	}
+	if n := utf8.RuneCountInString(text); n > maxTextLength() {
+		return fmt.Errorf("Message text can be at most %d characters, got %d.", maxTextLength(), n)
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return ErrControlCharacter
+		}
+	}
+	return nil
+}
+
+// sends an error frame for text that failed validateText
+func sendTextError(c *Client, correlationID string, err error) {
+	if errors.Is(err, ErrEmptyText) || errors.Is(err, ErrControlCharacter) {
+		sendError(c, correlationID, protocol.CodeInvalidText, err.Error())
+		return
+	}
+	sendError(c, correlationID, protocol.CodeMessageTooLarge, err.Error())
+}
+
+// the limits a client is held to, advertised in the welcome frame
+func clientLimits() protocol.LimitsData {
+	rateLimits := make(map[protocol.MessageType]protocol.RateLimitData)
+	for messageType := range defaultRateLimits {
+		limit := rateLimitFor(messageType)
+		rateLimits[messageType] = protocol.RateLimitData{PerSecond: limit.PerSecond, Burst: limit.Burst}
+	}
+	for messageType, limit := range config.App.Chat.RateLimits {
+		rateLimits[protocol.MessageType(messageType)] = protocol.RateLimitData{PerSecond: limit.PerSecond, Burst: limit.Burst}
+	}
+	return protocol.LimitsData{
+		MaxFrameBytes: maxFrameSize(),
+		MaxTextRunes:  maxTextLength(),
+		MaxRooms:      maxRoomsPerClient,
+		RateLimits:    rateLimits,
+	}
+}
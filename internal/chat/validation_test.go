@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"chatapp/internal/config"
+	"chatapp/pkg/protocol"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateText(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{MaxTextRunes: 5}}
+	tests := []struct {
+		name    string
+		text    string
+		want    error
+		tooLong bool // any other error, sent as message_too_large
+	}{
+		{"plain", "hello", nil, false},
+		{"empty", "", ErrEmptyText, false},
+		{"only spaces", "   ", ErrEmptyText, false},
+		{"only whitespace", " \n\t\r ", ErrEmptyText, false},
+		{"multibyte within the rune limit", "héllo", nil, false},
+		{"emoji count as one rune each", "😀😀😀😀😀", nil, false},
+		{"over the rune limit", "hello!", nil, true},
+		{"over the rune limit in emoji", "😀😀😀😀😀😀", nil, true},
+		{"newlines and tabs", "a\nb\tc", nil, false},
+		{"carriage return", "a\r\nb", nil, false},
+		{"null", "a\x00b", ErrControlCharacter, false},
+		{"escape", "a\x1bb", ErrControlCharacter, false},
+		{"delete", "a\x7fb", ErrControlCharacter, false},
+		{"c1 control", "a\u0085b", ErrControlCharacter, false},
+		{"zero width space isn't a control character", "a\u200bb", nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateText(test.text)
+			switch {
+			case test.tooLong:
+				if err == nil || errors.Is(err, ErrEmptyText) || errors.Is(err, ErrControlCharacter) {
+					t.Errorf("got %v, want a length error", err)
+				}
+			case test.want == nil:
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+			case !errors.Is(err, test.want):
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateTextLimitIsRunesNotBytes(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{MaxTextRunes: 4000}}
+	text := strings.Repeat("é", 4000) // 8000 bytes
+	if err := validateText(text); err != nil {
+		t.Errorf("got %v for %d runes", err, 4000)
+	}
+	if err := validateText(text + "é"); err == nil {
+		t.Errorf("no error for %d runes", 4001)
+	}
+}
+
+func TestClientLimits(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{
+		MaxFrameBytes: 1024,
+		MaxTextRunes:  100,
+		RateLimits: map[string]config.RateLimit{
+			"chat": {PerSecond: 10, Burst: 20},
+			"kick": {PerSecond: 3, Burst: 4},
+		},
+	}}
+	limits := clientLimits()
+	if limits.MaxFrameBytes != 1024 || limits.MaxTextRunes != 100 || limits.MaxRooms != maxRoomsPerClient {
+		t.Errorf("got limits %+v", limits)
+	}
+	want := map[protocol.MessageType]protocol.RateLimitData{
+		protocol.Chat:  {PerSecond: 10, Burst: 20},
+		protocol.Kick:  {PerSecond: 3, Burst: 4},
+		protocol.React: {PerSecond: defaultRateLimits[protocol.React].PerSecond, Burst: defaultRateLimits[protocol.React].Burst},
+	}
+	for messageType, limit := range want {
+		if limits.RateLimits[messageType] != limit {
+			t.Errorf("got %s limit %+v, want %+v", messageType, limits.RateLimits[messageType], limit)
+		}
+	}
+	if len(limits.RateLimits) != len(defaultRateLimits)+1 {
+		t.Errorf("got %d rate limits, want the %d defaults and kick", len(limits.RateLimits), len(defaultRateLimits))
+	}
+}
+
+func TestLimitsSentWithoutHello(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{MaxFrameBytes: 1024, MaxTextRunes: 100}}
+	c := NewClient("no-hello-user", "user", "", nil, nil)
+	dispatch(c, []byte(`{"type":"not_a_type","payload":{}}`))
+	dispatch(c, []byte(`{"type":"not_a_type","payload":{}}`))
+
+	frames := queued(c)
+	if len(frames) != 3 || frames[0].Type != protocol.Welcome {
+		t.Fatalf("got %d frames, want a welcome before the two errors", len(frames))
+	}
+	welcome := findPayload[protocol.WelcomeData](t, frames, protocol.Welcome)
+	if welcome.Limits.MaxTextRunes != 100 || welcome.ProtocolVersion != protocol.MinProtocolVersion {
+		t.Errorf("got welcome %+v", welcome)
+	}
+	if !slices.Equal(welcome.Features, protocol.Features()) {
+		t.Errorf("got features %v, want all of them", welcome.Features)
+	}
+}
+
+func TestHelloIsWelcomedOnce(t *testing.T) {
+	config.App = &config.Config{Chat: &config.ChatConfig{}}
+	c := NewClient("hello-user", "user", "", nil, nil)
+	dispatch(c, []byte(`{"type":"hello","payload":{"protocol_version":1,"features":["typing"]}}`))
+	dispatch(c, []byte(`{"type":"not_a_type","payload":{}}`))
+
+	frames := queued(c)
+	welcomes := 0
+	for _, frame := range frames {
+		if frame.Type == protocol.Welcome {
+			welcomes++
+		}
+	}
+	if welcomes != 1 {
+		t.Fatalf("got %d welcomes, want 1", welcomes)
+	}
+	if welcome := findPayload[protocol.WelcomeData](t, frames, protocol.Welcome); !slices.Equal(welcome.Features, []string{"typing"}) {
+		t.Errorf("got features %v", welcome.Features)
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
 type Config struct {
-	Port    string
-	BaseURL string
-	PG      *PGConfig
-	Email   *EmailConfig
-	Auth    *AuthConfig
+	Port            string
+	BaseURL         string
+	ShutdownTimeout time.Duration // how long to wait for connections to drain on SIGTERM before exiting
+	DebugVars       bool          // serves expvar metrics such as slow consumer counts at /debug/vars
+	PG              *PGConfig
+	Email           *EmailConfig
+	Auth            *AuthConfig
+	Chat            *ChatConfig
 }
 
 type PGConfig struct {
@@ -39,6 +46,7 @@ type EmailConfig struct {
 type AuthConfig struct {
 	AccessTokenKey       []byte
 	ActivationTokenKey   []byte
+	InviteTokenKey       []byte // separate from the activation key so invite links can't be used as activation or reset links
 	OAuthClientID        string
 	OAuthClientSecret    string
 	OAuthUserInfoURL     string
@@ -46,6 +54,27 @@ type AuthConfig struct {
 	OAuthConfig          oauth2.Config
 }
 
+type ChatConfig struct {
+	HistoryLimit int    // number of recent messages replayed to a client when joining a room
+	Broker       string // shares hub events between server instances, memory for a single instance or postgres
+	HubShards    int    // number of goroutines the hubs rooms are split across
+	// largest websocket message accepted in bytes, and longest message text in runes
+	MaxFrameBytes int
+	MaxTextRunes  int
+	// how long a disconnected users leave notice is held back, so reconnecting within it shows no leave or join notice
+	ReconnectGrace time.Duration
+	// what happens when a client's send buffer is full, disconnect, drop_oldest or coalesce
+	SlowConsumerPolicy string
+	// overrides of the default rate limits by websocket message type, e.g. chat=2/5 for 2 a second in bursts of 5
+	RateLimits map[string]RateLimit
+}
+
+// token bucket limit on how often a kind of message can be sent
+type RateLimit struct {
+	PerSecond float64 // tokens refilled per second
+	Burst     int     // most tokens the bucket holds, how many messages can be sent at once
+}
+
 var App *Config
 
 func Load() {
@@ -53,8 +82,10 @@ func Load() {
 	baseURL := getEnv("BASE_URL")
 
 	App = &Config{
-		Port:    getEnv("PORT"),
-		BaseURL: baseURL,
+		Port:            getEnv("PORT"),
+		BaseURL:         baseURL,
+		ShutdownTimeout: time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 15)) * time.Second,
+		DebugVars:       getEnvChoice("DEBUG_VARS", "false", "true") == "true",
 
 		PG: &PGConfig{
 			User:       getEnv("PG_USER"),
@@ -76,6 +107,7 @@ func Load() {
 		Auth: &AuthConfig{
 			AccessTokenKey:       []byte(getEnv("ACCESS_TOKEN_SECRET")),
 			ActivationTokenKey:   []byte(getEnv("ACTIVATION_TOKEN_SECRET")),
+			InviteTokenKey:       []byte(getEnv("INVITE_TOKEN_SECRET")),
 			OAuthClientID:        getEnv("OAUTH_CLIENT_ID"),
 			OAuthClientSecret:    getEnv("OAUTH_CLIENT_SECRET"),
 			OAuthUserInfoURL:     getEnv("OAUTH_USER_INFO_URL"),
@@ -88,6 +120,16 @@ func Load() {
 				Endpoint:     google.Endpoint,
 			},
 		},
+		Chat: &ChatConfig{
+			HistoryLimit:       getEnvInt("CHAT_HISTORY_LIMIT", 50),
+			Broker:             getEnvDefault("CHAT_BROKER", "memory"),
+			HubShards:          getEnvInt("CHAT_HUB_SHARDS", runtime.NumCPU()),
+			MaxFrameBytes:      getEnvInt("CHAT_MAX_FRAME_BYTES", 16*1024),
+			MaxTextRunes:       getEnvInt("CHAT_MAX_TEXT_RUNES", 4000),
+			ReconnectGrace:     time.Duration(getEnvInt("CHAT_RECONNECT_GRACE_SECONDS", 10)) * time.Second,
+			SlowConsumerPolicy: getEnvChoice("CHAT_SLOW_CONSUMER_POLICY", "disconnect", "drop_oldest", "coalesce"),
+			RateLimits:         getEnvRateLimits("CHAT_RATE_LIMITS"),
+		},
 	}
 }
 
@@ -99,6 +141,59 @@ func getEnv(key string) string {
 	return val
 }
 
+// optional environment variable, falls back to a default when unset
+func getEnvDefault(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
+// optional integer environment variable, falls back to a default when unset
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("Invalid integer for environment variable %s: %v", key, err)
+	}
+	return n
+}
+
+// optional environment variable that must be one of choices, the first choice is the default
+func getEnvChoice(key string, choices ...string) string {
+	val := getEnvDefault(key, choices[0])
+	for _, choice := range choices {
+		if val == choice {
+			return val
+		}
+	}
+	log.Fatalf("Invalid value %q for environment variable %s, expected one of %s", val, key, strings.Join(choices, ", "))
+	return ""
+}
+
+// optional comma separated type=rate/burst list, e.g. chat=2/5,react=4/10
+func getEnvRateLimits(key string) map[string]RateLimit {
+	limits := make(map[string]RateLimit)
+	val := os.Getenv(key)
+	if val == "" {
+		return limits
+	}
+	for _, entry := range strings.Split(val, ",") {
+		messageType, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		rate, burst, ok2 := strings.Cut(limit, "/")
+		perSecond, err := strconv.ParseFloat(rate, 64)
+		n, err2 := strconv.Atoi(burst)
+		if !ok || !ok2 || err != nil || err2 != nil || perSecond <= 0 || n < 1 {
+			log.Fatalf("Invalid rate limit %q for environment variable %s, expected type=rate/burst", entry, key)
+		}
+		limits[messageType] = RateLimit{PerSecond: perSecond, Burst: n}
+	}
+	return limits
+}
+
 func (pg *PGConfig) PgConnString() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		pg.User, pg.Password, pg.Host, pg.Port, pg.DBName, pg.SSLMode)
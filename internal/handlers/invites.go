@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/config"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HTTP handler for a room owner inviting a user by username
+func InviteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		return
+	}
+	invite, err := rooms.InviteUser(id, chi.URLParam(r, "roomID"), payload.Username)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	log.Printf("%s invited %s to Room %s", id, payload.Username, invite.RoomID)
+	writeJSON(w, http.StatusCreated, invite)
+}
+
+// HTTP handler for a room owner creating a signed invite link
+func CreateInviteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	token, err := rooms.CreateInviteLink(id, chi.URLParam(r, "roomID"))
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"token": token,
+		"url":   config.App.BaseURL + "/lobby?invite=" + url.QueryEscape(token),
+	})
+}
+
+// HTTP handler joining a room from an invite link token
+func AcceptInviteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		return
+	}
+	room, err := rooms.AcceptInviteLink(id, payload.Token)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// HTTP handler listing the current users pending invites
+func ListInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	invites, err := rooms.ListInvites(id)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+// HTTP handler accepting an invite and joining the room
+func AcceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	inviteID := chi.URLParam(r, "inviteID")
+	if !protocol.IsID(inviteID) {
+		writeRoomError(w, rooms.ErrInviteNotFound)
+		return
+	}
+	room, err := rooms.AcceptInvite(id, inviteID)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// HTTP handler declining an invite
+func DeclineInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	inviteID := chi.URLParam(r, "inviteID")
+	if !protocol.IsID(inviteID) {
+		writeRoomError(w, rooms.ErrInviteNotFound)
+		return
+	}
+	if err := rooms.DeclineInvite(id, inviteID); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/chat"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 100
+)
+
+// HTTP handler returning a page of stored messages in a room, older pages are requested with the before cursor
+func GetRoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	roomID := chi.URLParam(r, "roomID")
+	before, limit, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// private room history is only readable by members, and banned users can't read it
+	if err := rooms.CheckReadable(roomID, id); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	page, err := chat.LoadHistoryPage(roomID, id, before, limit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// HTTP handler returning a message in a room with every reply in its thread
+func GetThreadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	roomID := chi.URLParam(r, "roomID")
+	if err := rooms.CheckReadable(roomID, id); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	thread, err := chat.LoadThread(roomID, id, chi.URLParam(r, "messageID"))
+	if errors.Is(err, chat.ErrMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to load thread", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, thread)
+}
+
+// read the before cursor and page size from query params
+func parseHistoryQuery(r *http.Request) (string, int, error) {
+	before := r.URL.Query().Get("before")
+	if before != "" && !protocol.IsID(before) {
+		return "", 0, errors.New("Invalid before message ID.")
+	}
+	limit := defaultHistoryPageSize
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		n, err := strconv.Atoi(rawLimit)
+		if err != nil || n < 1 || n > maxHistoryPageSize {
+			return "", 0, errors.New("Limit must be a number between 1 and 100.")
+		}
+		limit = n
+	}
+	return before, limit, nil
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/rooms"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HTTP handler listing the rooms shown in the lobby, public rooms and private rooms the user is a member of
+func ListRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	roomList, err := rooms.List(id)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, roomList)
+}
+
+// HTTP handler returning the number of unread messages in every room listed in the lobby
+func UnreadCountsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	counts, err := rooms.UnreadCounts(id)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, counts)
+}
+
+// HTTP handler creating a new room owned by the current user
+func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Name       string           `json:"name"`
+		Topic      string           `json:"topic"`
+		Visibility rooms.Visibility `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		return
+	}
+	room, err := rooms.Create(id, payload.Name, payload.Topic, payload.Visibility)
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	log.Printf("Room %s (%s) created by %s", room.ID, room.Name, id)
+	writeJSON(w, http.StatusCreated, room)
+}
+
+// HTTP handler returning the details of a single room
+func GetRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	room, err := rooms.Describe(id, chi.URLParam(r, "roomID"))
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// HTTP handler renaming a room or changing its topic, fields left out of the payload are unchanged
+func UpdateRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Name  *string `json:"name"`
+		Topic *string `json:"topic"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		return
+	}
+	roomID := chi.URLParam(r, "roomID")
+	var room *rooms.Room
+	if payload.Name == nil && payload.Topic == nil {
+		room, err = rooms.Describe(id, roomID)
+	} else {
+		room, err = rooms.Update(id, roomID, payload.Name, payload.Topic)
+	}
+	if err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+// HTTP handler archiving a room so it can no longer be joined
+func ArchiveRoomHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	roomID := chi.URLParam(r, "roomID")
+	if err := rooms.Archive(id, roomID); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	log.Printf("Room %s archived by %s", roomID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HTTP handler for a room owner appointing or demoting a moderator
+func SetRoomRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := auth.GetClaimFromAccessCookie("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		Username string     `json:"username"`
+		Role     rooms.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request payload.", http.StatusBadRequest)
+		return
+	}
+	roomID := chi.URLParam(r, "roomID")
+	if err := rooms.SetRole(id, roomID, payload.Username, payload.Role); err != nil {
+		writeRoomError(w, err)
+		return
+	}
+	log.Printf("%s is now a %s in Room %s", payload.Username, payload.Role, roomID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maps errors from the rooms package to HTTP status codes
+func writeRoomError(w http.ResponseWriter, err error) {
+	var validationErr *rooms.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, rooms.ErrNotFound), errors.Is(err, rooms.ErrInviteNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, rooms.ErrNotOwner), errors.Is(err, rooms.ErrNotMember), errors.Is(err, rooms.ErrBanned):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, rooms.ErrArchived):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Println(err)
+		http.Error(w, "Failed to process room request", http.StatusInternalServerError)
+	}
+}
+
+// writes a JSON response body with a status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"chatapp/internal/auth"
-	"chatapp/internal/chat"
 	"chatapp/internal/postgres"
+	"chatapp/pkg/protocol"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,7 +24,7 @@ func GetUserInfoHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-	var userInfo = chat.UserItem{
+	var userInfo = protocol.UserItem{
 		ID:       id,
 		Username: username,
 	}
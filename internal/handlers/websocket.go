@@ -3,7 +3,10 @@ package handlers
 import (
 	"chatapp/internal/auth"
 	"chatapp/internal/chat"
+	"chatapp/internal/config"
 	"chatapp/internal/postgres"
+	"chatapp/internal/rooms"
+	"chatapp/pkg/protocol"
 	"log"
 	"net/http"
 
@@ -14,20 +17,33 @@ import (
 var upgrader = websocket.Upgrader{ // websocket buffers use send/recv internally, just a pointer to userspace buffer
 	WriteBufferSize: 1024, // I/O buffer sizes in user space, this is different from TCP buffer in kernel memory
 	ReadBufferSize:  1024, // read and write buffers can only process 1 websocket frame at a time
+	// clients asking for the msgpack subprotocol get MessagePack binary frames, everyone else gets JSON text frames
+	Subprotocols: []string{protocol.MsgpackSubprotocol, protocol.JSONSubprotocol},
+	// per-message-deflate for clients that offer it, browsers do by default
+	EnableCompression: true,
 }
 
 // establish the websocket connection with client here
 func ServeWsConn(hub *chat.Hub, w http.ResponseWriter, r *http.Request) {
+	// the server is shutting down, the client should retry against another instance or after the restart
+	if !hub.Accepting() {
+		http.Error(w, "Server restarting", http.StatusServiceUnavailable)
+		return
+	}
 	// get userID, username from HTTP only cookie to populate name
 	id, username, err := getClientInfo(w, r)
 	if err != nil {
 		log.Println(err)
 		return // would've already wrote error to response, just return
 	}
+	// room_id is optional, it is joined straight away and more rooms can be joined over the connection
 	roomID := r.URL.Query().Get("room_id")
-	if roomID == "" {
-		http.Error(w, "Missing room_id", http.StatusBadRequest)
-		return
+	// only registered rooms that haven't been archived can be joined, private rooms need membership
+	if roomID != "" {
+		if err := rooms.CheckJoinable(roomID, id); err != nil {
+			writeRoomError(w, err)
+			return
+		}
 	}
 
 	// upgrade connection from HTTP to WebSocket protocol
@@ -36,13 +52,26 @@ func ServeWsConn(hub *chat.Hub, w http.ResponseWriter, r *http.Request) {
 		log.Println("WebSocket upgrade failed:", err)
 		return
 	}
-	log.Printf("Opened a new connection with %s in Room %s", username, roomID)
+	if roomID != "" {
+		log.Printf("Opened a new connection with %s in Room %s", username, roomID)
+	} else {
+		log.Printf("Opened a new connection with %s", username)
+	}
 
 	// create new client for the connection
 	client := chat.NewClient(id, username, roomID, hub, conn)
-	hub.RegisterClient(client)   // push onto hub register channel
-	go client.ReceiveWsMessage() // receive websocket frames on separate thread
-	go client.SendWsMessage()    // send websocket frames on separate thread
+	// replay recent room messages before live ones, or only the ones missed since last_seen_message_id when resuming
+	if lastSeenID := r.URL.Query().Get("last_seen_message_id"); lastSeenID != "" {
+		client.SendMissedMessages(lastSeenID)
+	} else {
+		client.SendHistory(config.App.Chat.HistoryLimit)
+	}
+	client.SendPendingDirectMessages() // deliver direct messages sent while offline
+	hub.RegisterClient(client)         // push onto hub register channel
+	// the hub handles direct messages after taking the registration, so ones it handled before were stored before
+	// this second load and only it can deliver them
+	client.SendPendingDirectMessages()
+	hub.Serve(client) // receive and send websocket frames on separate threads
 }
 
 // retrieve the users id and username from the first HTTP1.1 req that
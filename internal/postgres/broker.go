@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// how long a large broker payload is kept for listeners to load it
+const brokerPayloadTTL = "1 minute"
+
+// publishes a payload on a LISTEN/NOTIFY channel
+func Notify(channel, payload string) error {
+	_, err := DB.Exec(`SELECT pg_notify($1, $2)`, channel, payload)
+	return err
+}
+
+// stores a payload too large for NOTIFY and returns its id, payloads older than a minute are deleted
+func SaveBrokerPayload(payload string) (string, error) {
+	var id int64
+	err := DB.QueryRow(
+		`WITH expired AS (
+			DELETE FROM broker_payloads WHERE created_at < CURRENT_TIMESTAMP - $2::interval
+		)
+		INSERT INTO broker_payloads (payload) VALUES ($1) RETURNING id`,
+		payload, brokerPayloadTTL,
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// loads a payload stored by SaveBrokerPayload
+func GetBrokerPayload(id string) (string, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("Invalid broker payload id %q: %w", id, err)
+	}
+	var payload string
+	err = DB.QueryRow(`SELECT payload FROM broker_payloads WHERE id = $1`, n).Scan(&payload)
+	return payload, err
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// an invitation to a room joined with the room name and inviter username
+type RoomInvite struct {
+	ID              string
+	RoomID          string
+	RoomName        string
+	InviterID       string
+	InviterUsername string
+	InviteeID       string
+	Status          string
+	CreatedAt       time.Time
+}
+
+// add a user to a room, does nothing if they are already a member
+func AddRoomMember(roomID, userID string) (err error) {
+	_, err = DB.Exec(
+		`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		roomID, userID,
+	)
+	return
+}
+
+// return true if the user is a member of the room
+func IsRoomMember(roomID, userID string) (isMember bool, err error) {
+	err = DB.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID,
+	).Scan(&isMember)
+	return
+}
+
+// create a pending invite, re-inviting a user who declined resets their invite to pending
+func CreateRoomInvite(roomID, inviterID, inviteeID string) (id string, err error) {
+	err = DB.QueryRow(
+		`INSERT INTO room_invites (room_id, inviter_id, invitee_id) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, invitee_id)
+		DO UPDATE SET
+			inviter_id = EXCLUDED.inviter_id,
+			status = 'pending',
+			created_at = CURRENT_TIMESTAMP,
+			responded_at = NULL
+		RETURNING id`, roomID, inviterID, inviteeID,
+	).Scan(&id)
+	return
+}
+
+const inviteQuery = `SELECT i.id, i.room_id, r.name, i.inviter_id, u.username, i.invitee_id, i.status, i.created_at
+	FROM room_invites i
+	JOIN rooms r ON r.id = i.room_id
+	JOIN users u ON u.id = i.inviter_id`
+
+func scanInvite(row interface{ Scan(...any) error }) (invite RoomInvite, err error) {
+	err = row.Scan(&invite.ID, &invite.RoomID, &invite.RoomName, &invite.InviterID, &invite.InviterUsername,
+		&invite.InviteeID, &invite.Status, &invite.CreatedAt)
+	return
+}
+
+// get an invite by id
+func GetRoomInvite(id string) (RoomInvite, error) {
+	return scanInvite(DB.QueryRow(inviteQuery+` WHERE i.id = $1`, id))
+}
+
+// get the pending invites for a user to rooms that haven't been archived, newest first
+func ListPendingInvites(inviteeID string) ([]RoomInvite, error) {
+	rows, err := DB.Query(
+		inviteQuery+` WHERE i.invitee_id = $1 AND i.status = 'pending' AND r.archived_at IS NULL
+		ORDER BY i.created_at DESC`, inviteeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []RoomInvite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// accept a pending invite and add the invitee to the room in one transaction
+func AcceptRoomInvite(id string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID, inviteeID string
+	err = tx.QueryRow(
+		`UPDATE room_invites SET status = 'accepted', responded_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'pending'
+		RETURNING room_id, invitee_id`, id,
+	).Scan(&roomID, &inviteeID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		roomID, inviteeID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// decline a pending invite
+func DeclineRoomInvite(id string) error {
+	res, err := DB.Exec(
+		`UPDATE room_invites SET status = 'declined', responded_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'pending'`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// a chat message row joined with the senders current username
+type Message struct {
+	ID             string
+	RoomID         string
+	SenderID       string
+	SenderUsername string
+	ReceiverID     string // only set for direct messages
+	Text           string // empty once deleted
+	CreatedAt      time.Time
+	EditedAt       sql.NullTime
+	DeletedAt      sql.NullTime
+	ParentID       string // only set for thread replies
+	ReplyCount     int    // only set for thread parents
+	LastReplyAt    sql.NullTime
+}
+
+// columns selected from messages m joined with users u on the sender, scanned by scanMessages
+const messageColumns = `m.id, COALESCE(m.room_id, ''), m.sender_id, u.username, COALESCE(m.receiver_id::text, ''),
+	CASE WHEN m.deleted_at IS NULL THEN m.text ELSE '' END, m.created_at, m.edited_at, m.deleted_at,
+	COALESCE(m.parent_message_id::text, ''), m.reply_count, m.last_reply_at`
+
+// scan every row of a query selecting messageColumns
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.SenderID, &m.SenderUsername, &m.ReceiverID,
+			&m.Text, &m.CreatedAt, &m.EditedAt, &m.DeletedAt, &m.ParentID, &m.ReplyCount, &m.LastReplyAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// save a message sent to a room and return the generated id and timestamp
+// a non-empty parentID makes the message a thread reply and updates the reply count and last reply time of the parent
+// if the sender already sent a message with the same non-empty clientMsgID, the original is returned with duplicate set
+func CreateRoomMessage(roomID, senderID, parentID, text, clientMsgID string) (id string, createdAt time.Time, duplicate bool, err error) {
+	return insertMessage(roomID, senderID, "", parentID, text, clientMsgID)
+}
+
+// inserts a room or direct message, deduplicating on the senders client message ID within the room or receiver
+func insertMessage(roomID, senderID, receiverID, parentID, text, clientMsgID string) (id string, createdAt time.Time, duplicate bool, err error) {
+	err = DB.QueryRow(
+		`WITH inserted AS (
+			INSERT INTO messages (room_id, sender_id, receiver_id, parent_message_id, text, client_msg_id)
+			VALUES (NULLIF($1, ''), $2, NULLIF($3, '')::uuid, NULLIF($4, '')::uuid, $5, NULLIF($6, ''))
+			ON CONFLICT (sender_id, room_id, receiver_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
+			RETURNING id, created_at, parent_message_id
+		), parent AS (
+			UPDATE messages SET reply_count = reply_count + 1, last_reply_at = inserted.created_at
+			FROM inserted WHERE messages.id = inserted.parent_message_id
+		)
+		SELECT id, created_at, false FROM inserted
+		UNION ALL
+		SELECT id, created_at, true FROM messages
+		WHERE sender_id = $2 AND client_msg_id = NULLIF($6, '')
+		AND room_id IS NOT DISTINCT FROM NULLIF($1, '') AND receiver_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid
+		AND NOT EXISTS (SELECT 1 FROM inserted)`,
+		roomID, senderID, receiverID, parentID, text, clientMsgID,
+	).Scan(&id, &createdAt, &duplicate)
+	if err == sql.ErrNoRows && clientMsgID != "" {
+		// a concurrent retransmit inserted the message after this statements snapshot was taken, so neither branch
+		// saw a row, the conflict means it has committed by now and a new statement can see it
+		err = DB.QueryRow(
+			`SELECT id, created_at, true FROM messages
+			WHERE sender_id = $2 AND client_msg_id = $4
+			AND room_id IS NOT DISTINCT FROM NULLIF($1, '') AND receiver_id IS NOT DISTINCT FROM NULLIF($3, '')::uuid`,
+			roomID, senderID, receiverID, clientMsgID,
+		).Scan(&id, &createdAt, &duplicate)
+	}
+	return
+}
+
+// get the most recent messages in a room, ordered oldest to newest
+func GetRecentRoomMessages(roomID string, limit int) ([]Message, error) {
+	return GetRoomMessagesBefore(roomID, "", limit)
+}
+
+// get a page of messages in a room sent before the message with id before (or the newest if before is empty),
+// ordered oldest to newest
+func GetRoomMessagesBefore(roomID, before string, limit int) ([]Message, error) {
+	rows, err := DB.Query(
+		`SELECT * FROM (
+			SELECT `+messageColumns+`
+			FROM messages m JOIN users u ON u.id = m.sender_id
+			WHERE m.room_id = $1
+			AND ($2::text = '' OR (m.created_at, m.id) < (
+				SELECT created_at, id FROM messages WHERE id = NULLIF($2, '')::uuid AND room_id = $1
+			))
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $3
+		) page ORDER BY created_at, id`, roomID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// get messages in a room sent after the message with id after, ordered oldest to newest
+// returns no messages if after isn't a message in the room
+func GetRoomMessagesAfter(roomID, after string, limit int) ([]Message, error) {
+	rows, err := DB.Query(
+		`SELECT `+messageColumns+`
+		FROM messages m JOIN users u ON u.id = m.sender_id
+		WHERE m.room_id = $1
+		AND (m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = $2::uuid AND room_id = $1)
+		ORDER BY m.created_at, m.id
+		LIMIT $3`, roomID, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// get every reply in a thread, ordered oldest to newest
+func GetThreadReplies(parentID string) ([]Message, error) {
+	rows, err := DB.Query(
+		`SELECT `+messageColumns+`
+		FROM messages m JOIN users u ON u.id = m.sender_id
+		WHERE m.parent_message_id = $1
+		ORDER BY m.created_at, m.id`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// save a direct message between two users, it stays undelivered until it reaches the receiver
+// deduplicates on clientMsgID the same way as CreateRoomMessage
+func CreateDirectMessage(senderID, receiverID, text, clientMsgID string) (id string, createdAt time.Time, duplicate bool, err error) {
+	return insertMessage("", senderID, receiverID, "", text, clientMsgID)
+}
+
+// get direct messages queued for a receiver while they were offline, ordered oldest to newest
+func GetUndeliveredDirectMessages(receiverID string, limit int) ([]Message, error) {
+	rows, err := DB.Query(
+		`SELECT `+messageColumns+`
+		FROM messages m JOIN users u ON u.id = m.sender_id
+		WHERE m.receiver_id = $1 AND m.delivered_at IS NULL
+		ORDER BY m.created_at, m.id
+		LIMIT $2`, receiverID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanMessages(rows)
+}
+
+// mark direct messages as delivered so they are not queued again
+func MarkMessagesDelivered(ids []string) (err error) {
+	_, err = DB.Exec(
+		`UPDATE messages SET delivered_at = CURRENT_TIMESTAMP WHERE id = ANY($1::uuid[]) AND delivered_at IS NULL`,
+		pq.Array(ids),
+	)
+	return
+}
+
+// get a single message by id, returns sql.ErrNoRows if it doesn't exist
+func GetMessage(id string) (Message, error) {
+	rows, err := DB.Query(`SELECT `+messageColumns+` FROM messages m JOIN users u ON u.id = m.sender_id WHERE m.id = $1`, id)
+	if err != nil {
+		return Message{}, err
+	}
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return Message{}, err
+	}
+	if len(messages) == 0 {
+		return Message{}, sql.ErrNoRows
+	}
+	return messages[0], nil
+}
+
+// replace the text of a message that hasn't been deleted, keeping the previous text in the edit history
+func EditMessage(id, editorID, text string) (editedAt time.Time, err error) {
+	err = withMessageEdit(id, editorID, func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			`UPDATE messages SET text = $2, edited_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING edited_at`,
+			id, text,
+		).Scan(&editedAt)
+	})
+	return
+}
+
+// delete a message, leaving a tombstone row and its last text in the edit history
+func DeleteMessage(id, editorID string) (deletedAt time.Time, err error) {
+	err = withMessageEdit(id, editorID, func(tx *sql.Tx) error {
+		return tx.QueryRow(
+			`UPDATE messages SET text = '', deleted_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING deleted_at`,
+			id,
+		).Scan(&deletedAt)
+	})
+	return
+}
+
+// records the current text of a message in the edit history then applies a change in the same transaction
+// returns sql.ErrNoRows if the message doesn't exist or was already deleted
+func withMessageEdit(id, editorID string, change func(*sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO message_edits (message_id, editor_id, previous_text)
+		SELECT id, $2, text FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		id, editorID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
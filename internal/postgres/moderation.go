@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// get a users role in a room, the room owner is always owner and anyone without a membership role is a member
+func GetRoomRole(roomID, userID string) (role string, err error) {
+	err = DB.QueryRow(
+		`SELECT CASE
+			WHEN r.owner_id = $2 THEN 'owner'
+			ELSE COALESCE((SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2), 'member')
+		END
+		FROM rooms r WHERE r.id = $1`, roomID, userID,
+	).Scan(&role)
+	return
+}
+
+// set a users role in a room, adding them as a member if they aren't one yet
+func SetRoomRole(roomID, userID, role string) (err error) {
+	_, err = DB.Exec(
+		`INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (room_id, user_id) DO UPDATE SET role = EXCLUDED.role`,
+		roomID, userID, role,
+	)
+	return
+}
+
+// ban a user from a room, a nil expiresAt bans them permanently
+func BanUser(roomID, userID, bannedBy, reason string, expiresAt *time.Time) (err error) {
+	_, err = DB.Exec(
+		`INSERT INTO room_bans (room_id, user_id, banned_by, reason, expires_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room_id, user_id)
+		DO UPDATE SET
+			banned_by = EXCLUDED.banned_by,
+			reason = EXCLUDED.reason,
+			expires_at = EXCLUDED.expires_at,
+			created_at = CURRENT_TIMESTAMP`,
+		roomID, userID, bannedBy, reason, expiresAt,
+	)
+	return
+}
+
+func UnbanUser(roomID, userID string) (err error) {
+	_, err = DB.Exec(`DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	return
+}
+
+// get an active ban on a user in a room, returns sql.ErrNoRows if they aren't banned
+func GetActiveBan(roomID, userID string) (reason string, expiresAt sql.NullTime, err error) {
+	err = DB.QueryRow(
+		`SELECT reason, expires_at FROM room_bans
+		WHERE room_id = $1 AND user_id = $2 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		roomID, userID,
+	).Scan(&reason, &expiresAt)
+	return
+}
+
+// mute a user in a room, a nil expiresAt mutes them until they are unmuted
+func MuteUser(roomID, userID, mutedBy string, expiresAt *time.Time) (err error) {
+	_, err = DB.Exec(
+		`INSERT INTO room_mutes (room_id, user_id, muted_by, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id, user_id)
+		DO UPDATE SET
+			muted_by = EXCLUDED.muted_by,
+			expires_at = EXCLUDED.expires_at,
+			created_at = CURRENT_TIMESTAMP`,
+		roomID, userID, mutedBy, expiresAt,
+	)
+	return
+}
+
+func UnmuteUser(roomID, userID string) (err error) {
+	_, err = DB.Exec(`DELETE FROM room_mutes WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+	return
+}
+
+// get an active mute on a user in a room, returns sql.ErrNoRows if they aren't muted
+func GetActiveMute(roomID, userID string) (expiresAt sql.NullTime, err error) {
+	err = DB.QueryRow(
+		`SELECT expires_at FROM room_mutes
+		WHERE room_id = $1 AND user_id = $2 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		roomID, userID,
+	).Scan(&expiresAt)
+	return
+}
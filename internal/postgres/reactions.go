@@ -0,0 +1,55 @@
+package postgres
+
+import "github.com/lib/pq"
+
+// the number of users who reacted to a message with an emoji
+type Reaction struct {
+	MessageID string
+	Emoji     string
+	Count     int
+	Reacted   bool // the user the reactions were loaded for is one of them
+}
+
+// adds a users reaction to a message, or removes it if they already reacted with that emoji
+// returns true if the reaction was added
+func ToggleReaction(messageID, userID, emoji string) (added bool, err error) {
+	err = DB.QueryRow(
+		`WITH removed AS (
+			DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3 RETURNING 1
+		), inserted AS (
+			INSERT INTO message_reactions (message_id, user_id, emoji)
+			SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM removed)
+			ON CONFLICT DO NOTHING
+			RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM inserted)`,
+		messageID, userID, emoji,
+	).Scan(&added)
+	return
+}
+
+// get the reactions on a set of messages for a user, grouped by message and ordered by first reaction
+func GetReactions(messageIDs []string, userID string) ([]Reaction, error) {
+	rows, err := DB.Query(
+		`SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id::text = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1::uuid[])
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji`,
+		pq.Array(messageIDs), userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []Reaction
+	for rows.Next() {
+		var r Reaction
+		if err := rows.Scan(&r.MessageID, &r.Emoji, &r.Count, &r.Reacted); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	return reactions, rows.Err()
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// the number of unread messages in a room
+type UnreadCount struct {
+	RoomID string
+	Unread int
+}
+
+// move a users read position in a room forward to a message in that room
+// returns false without changing anything if they already read up to a later message
+func SetReadUpTo(roomID, userID, messageID string) (advanced bool, readAt time.Time, err error) {
+	err = DB.QueryRow(
+		`INSERT INTO room_reads (room_id, user_id, message_id, read_at)
+		SELECT room_id, $2, id, created_at FROM messages WHERE id = $3 AND room_id = $1
+		ON CONFLICT (room_id, user_id) DO UPDATE SET
+			message_id = EXCLUDED.message_id,
+			read_at = EXCLUDED.read_at,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE (room_reads.read_at, room_reads.message_id) < (EXCLUDED.read_at, EXCLUDED.message_id)
+		RETURNING read_at`,
+		roomID, userID, messageID,
+	).Scan(&readAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, readAt, nil
+	}
+	return err == nil, readAt, err
+}
+
+// count messages from other users after the users read position in every room listed for them by ListRoomsForUser
+// deleted messages aren't counted, and every message is unread in a room the user hasn't read yet
+// rooms the user is banned from are left out, their history can't be read to clear the count
+func GetUnreadCounts(userID string) ([]UnreadCount, error) {
+	rows, err := DB.Query(
+		`SELECT r.id, COUNT(m.id)
+		FROM rooms r
+		LEFT JOIN room_reads rr ON rr.room_id = r.id AND rr.user_id = $1
+		LEFT JOIN messages m ON m.room_id = r.id
+			AND m.sender_id <> $1
+			AND m.deleted_at IS NULL
+			AND (rr.read_at IS NULL OR (m.created_at, m.id) > (rr.read_at, rr.message_id))
+		WHERE r.archived_at IS NULL
+		AND (r.visibility = 'public' OR EXISTS (SELECT 1 FROM room_members WHERE room_id = r.id AND user_id = $1))
+		AND NOT EXISTS (
+			SELECT 1 FROM room_bans
+			WHERE room_id = r.id AND user_id = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		)
+		GROUP BY r.id
+		ORDER BY r.created_at, r.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []UnreadCount
+	for rows.Next() {
+		var c UnreadCount
+		if err := rows.Scan(&c.RoomID, &c.Unread); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
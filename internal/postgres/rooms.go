@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+// a room row, OwnerID is empty for rooms created by the server
+type Room struct {
+	ID         string
+	Name       string
+	Topic      string
+	OwnerID    string
+	Visibility string
+	CreatedAt  time.Time
+	ArchivedAt sql.NullTime
+}
+
+const roomColumns = `id, name, topic, COALESCE(owner_id::text, ''), visibility, created_at, archived_at`
+
+// scan a row selected with roomColumns
+func scanRoom(row interface{ Scan(...any) error }) (room Room, err error) {
+	err = row.Scan(&room.ID, &room.Name, &room.Topic, &room.OwnerID, &room.Visibility, &room.CreatedAt, &room.ArchivedAt)
+	return
+}
+
+// create a new room with the owner as its first member and return it with its generated id
+func CreateRoom(name, topic, ownerID, visibility string) (Room, error) {
+	return scanRoom(DB.QueryRow(
+		`WITH room AS (
+			INSERT INTO rooms (name, topic, owner_id, visibility) VALUES ($1, $2, $3, $4) RETURNING *
+		), member AS (
+			INSERT INTO room_members (room_id, user_id, role) SELECT id, owner_id, 'owner' FROM room
+		)
+		SELECT `+roomColumns+` FROM room`,
+		name, topic, ownerID, visibility,
+	))
+}
+
+// get a room by id, including archived rooms
+func GetRoom(id string) (Room, error) {
+	return scanRoom(DB.QueryRow(`SELECT `+roomColumns+` FROM rooms WHERE id = $1`, id))
+}
+
+// get every room that hasn't been archived and is either public or has the user as a member, oldest first
+func ListRoomsForUser(userID string) ([]Room, error) {
+	rows, err := DB.Query(
+		`SELECT `+roomColumns+` FROM rooms
+		WHERE archived_at IS NULL
+		AND (visibility = 'public' OR EXISTS (SELECT 1 FROM room_members WHERE room_id = rooms.id AND user_id = $1))
+		ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// update the name and topic of a room
+func UpdateRoom(id, name, topic string) (err error) {
+	_, err = DB.Exec(`UPDATE rooms SET name = $1, topic = $2 WHERE id = $3`, name, topic, id)
+	return
+}
+
+// archive a room so it can no longer be joined
+func ArchiveRoom(id string) (err error) {
+	_, err = DB.Exec(`UPDATE rooms SET archived_at = CURRENT_TIMESTAMP WHERE id = $1 AND archived_at IS NULL`, id)
+	return
+}
@@ -45,6 +45,12 @@ func GetUsernameById(id string) (string, error) {
 	return username, err
 }
 
+// get a user ID from their username
+func GetUserIdByUsername(username string) (id string, err error) {
+	err = DB.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	return
+}
+
 // get a username and ID from email
 func GetUserIdByEmail(email string) (id string, err error) {
 	err = DB.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&id)
@@ -0,0 +1,159 @@
+package rooms
+
+import (
+	"chatapp/internal/auth"
+	"chatapp/internal/postgres"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInviteNotFound = errors.New("Invite not found.")
+
+// an invitation for a user to join a room
+type Invite struct {
+	ID              string    `json:"id"`
+	RoomID          string    `json:"room_id"`
+	RoomName        string    `json:"room_name"`
+	InviterID       string    `json:"inviter_id"`
+	InviterUsername string    `json:"inviter_username"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func inviteFromRow(row postgres.RoomInvite) *Invite {
+	return &Invite{
+		ID:              row.ID,
+		RoomID:          row.RoomID,
+		RoomName:        row.RoomName,
+		InviterID:       row.InviterID,
+		InviterUsername: row.InviterUsername,
+		Status:          row.Status,
+		CreatedAt:       row.CreatedAt,
+	}
+}
+
+// invite a user to a room by username, only the owner can invite
+func InviteUser(ownerID, roomID, username string) (*Invite, error) {
+	if _, err := getOwnedActiveRoom(ownerID, roomID); err != nil {
+		return nil, err
+	}
+	inviteeID, err := postgres.GetUserIdByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &ValidationError{"No user with that username."}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up user %s: %w", username, err)
+	}
+	isMember, err := postgres.IsRoomMember(roomID, inviteeID)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking membership of room %s: %w", roomID, err)
+	}
+	if isMember {
+		return nil, &ValidationError{"User is already a member of this room."}
+	}
+	id, err := postgres.CreateRoomInvite(roomID, ownerID, inviteeID)
+	if err != nil {
+		return nil, fmt.Errorf("Error inviting %s to room %s: %w", username, roomID, err)
+	}
+	row, err := postgres.GetRoomInvite(id)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching invite %s: %w", id, err)
+	}
+	return inviteFromRow(row), nil
+}
+
+// create a signed invite link token for a room, only the owner can create one
+func CreateInviteLink(ownerID, roomID string) (string, error) {
+	if _, err := getOwnedActiveRoom(ownerID, roomID); err != nil {
+		return "", err
+	}
+	token, err := auth.CreateRoomInviteToken(roomID, ownerID)
+	if err != nil {
+		return "", fmt.Errorf("Error creating invite link for room %s: %w", roomID, err)
+	}
+	return token, nil
+}
+
+// join a room with an invite link token, the link stops working if its creator no longer owns the room
+func AcceptInviteLink(userID, token string) (*Room, error) {
+	roomID, inviterID, err := auth.GetRoomInviteClaims(token)
+	if err != nil {
+		return nil, &ValidationError{err.Error()}
+	}
+	room, err := getOwnedActiveRoom(inviterID, roomID)
+	if errors.Is(err, ErrNotOwner) {
+		return nil, &ValidationError{"Invite link is no longer valid."}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := postgres.AddRoomMember(roomID, userID); err != nil {
+		return nil, fmt.Errorf("Error adding member to room %s: %w", roomID, err)
+	}
+	return room, nil
+}
+
+// list the pending invites for a user
+func ListInvites(userID string) ([]*Invite, error) {
+	rows, err := postgres.ListPendingInvites(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing invites: %w", err)
+	}
+	invites := make([]*Invite, 0, len(rows))
+	for _, row := range rows {
+		invites = append(invites, inviteFromRow(row))
+	}
+	return invites, nil
+}
+
+// accept a pending invite addressed to the user and join the room
+func AcceptInvite(userID, inviteID string) (*Room, error) {
+	invite, err := getPendingInvite(userID, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	room, err := describe(invite.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Archived {
+		return nil, ErrArchived
+	}
+	if err := postgres.AcceptRoomInvite(inviteID); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound // responded to concurrently
+	} else if err != nil {
+		return nil, fmt.Errorf("Error accepting invite %s: %w", inviteID, err)
+	}
+	return room, nil
+}
+
+// decline a pending invite addressed to the user
+func DeclineInvite(userID, inviteID string) error {
+	if _, err := getPendingInvite(userID, inviteID); err != nil {
+		return err
+	}
+	if err := postgres.DeclineRoomInvite(inviteID); errors.Is(err, sql.ErrNoRows) {
+		return ErrInviteNotFound
+	} else if err != nil {
+		return fmt.Errorf("Error declining invite %s: %w", inviteID, err)
+	}
+	return nil
+}
+
+// get an invite that is addressed to the user and hasn't been responded to
+func getPendingInvite(userID, inviteID string) (*postgres.RoomInvite, error) {
+	row, err := postgres.GetRoomInvite(inviteID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching invite %s: %w", inviteID, err)
+	}
+	// don't reveal invites addressed to someone else
+	if row.InviteeID != userID || row.Status != "pending" {
+		return nil, ErrInviteNotFound
+	}
+	return &row, nil
+}
@@ -0,0 +1,176 @@
+package rooms
+
+import (
+	"chatapp/internal/postgres"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Role string
+
+const (
+	Owner     Role = "owner"     // created the room, can moderate anyone and appoint moderators
+	Moderator Role = "moderator" // can kick, ban and mute members
+	Member    Role = "member"    // anyone else in the room
+)
+
+var (
+	ErrBanned       = errors.New("You are banned from this room.")
+	ErrMuted        = errors.New("You are muted in this room.")
+	ErrNotModerator = errors.New("Only room moderators can do that.")
+	ErrOutranked    = errors.New("You can't moderate a user with an equal or higher role.")
+)
+
+// higher ranked roles can moderate lower ranked ones
+func (r Role) rank() int {
+	switch r {
+	case Owner:
+		return 2
+	case Moderator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// get a users role in a room
+func RoleOf(roomID, userID string) (Role, error) {
+	role, err := postgres.GetRoomRole(roomID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error fetching role in room %s: %w", roomID, err)
+	}
+	return Role(role), nil
+}
+
+// returns nil if the actor is a moderator or owner that outranks the target in the room
+func CheckCanModerate(roomID, actorID, targetID string) error {
+	actorRole, err := RoleOf(roomID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole.rank() < Moderator.rank() {
+		return ErrNotModerator
+	}
+	targetRole, err := RoleOf(roomID, targetID)
+	if err != nil {
+		return err
+	}
+	if targetRole.rank() >= actorRole.rank() {
+		return ErrOutranked
+	}
+	return nil
+}
+
+// returns ErrNotModerator unless the user is a moderator or owner of the room
+func CheckIsModerator(roomID, userID string) error {
+	role, err := RoleOf(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if role.rank() < Moderator.rank() {
+		return ErrNotModerator
+	}
+	return nil
+}
+
+// appoint or demote a moderator by username, only the owner can change roles
+func SetRole(ownerID, roomID, username string, role Role) error {
+	if role != Moderator && role != Member {
+		return &ValidationError{"Role must be moderator or member."}
+	}
+	if _, err := getOwnedActiveRoom(ownerID, roomID); err != nil {
+		return err
+	}
+	userID, err := postgres.GetUserIdByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &ValidationError{"No user with that username."}
+	}
+	if err != nil {
+		return fmt.Errorf("Error looking up user %s: %w", username, err)
+	}
+	if userID == ownerID {
+		return &ValidationError{"The owner's role can't be changed."}
+	}
+	if err := postgres.SetRoomRole(roomID, userID, string(role)); err != nil {
+		return fmt.Errorf("Error setting role in room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+// ban a user from a room, a zero duration bans them permanently
+func Ban(roomID, userID, bannedBy, reason string, duration time.Duration) error {
+	if err := postgres.BanUser(roomID, userID, bannedBy, reason, expiryFromDuration(duration)); err != nil {
+		return fmt.Errorf("Error banning user from room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+func Unban(roomID, userID string) error {
+	if err := postgres.UnbanUser(roomID, userID); err != nil {
+		return fmt.Errorf("Error unbanning user from room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+// mute a user in a room, a zero duration mutes them until they are unmuted
+func Mute(roomID, userID, mutedBy string, duration time.Duration) error {
+	if err := postgres.MuteUser(roomID, userID, mutedBy, expiryFromDuration(duration)); err != nil {
+		return fmt.Errorf("Error muting user in room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+func Unmute(roomID, userID string) error {
+	if err := postgres.UnmuteUser(roomID, userID); err != nil {
+		return fmt.Errorf("Error unmuting user in room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+// returns ErrBanned if the user has an active ban in the room
+func CheckNotBanned(roomID, userID string) error {
+	reason, expiresAt, err := postgres.GetActiveBan(roomID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error checking bans in room %s: %w", roomID, err)
+	}
+	err = ErrBanned
+	if reason != "" {
+		err = fmt.Errorf("%w Reason: %s.", err, reason)
+	}
+	if expiresAt.Valid {
+		err = fmt.Errorf("%w The ban ends at %s.", err, expiresAt.Time.UTC().Format(time.RFC1123))
+	}
+	return err
+}
+
+// returns ErrMuted if the user has an active mute in the room
+func CheckCanChat(roomID, userID string) error {
+	expiresAt, err := postgres.GetActiveMute(roomID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error checking mutes in room %s: %w", roomID, err)
+	}
+	if expiresAt.Valid {
+		return fmt.Errorf("%w The mute ends at %s.", ErrMuted, expiresAt.Time.UTC().Format(time.RFC1123))
+	}
+	return ErrMuted
+}
+
+// converts a ban or mute duration to an expiry time, nil for no expiry
+func expiryFromDuration(duration time.Duration) *time.Time {
+	if duration <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(duration)
+	return &expiresAt
+}
@@ -0,0 +1,25 @@
+package rooms
+
+import (
+	"chatapp/internal/postgres"
+	"fmt"
+)
+
+// the number of messages in a room the user hasn't read yet
+type UnreadCount struct {
+	RoomID string `json:"room_id"`
+	Unread int    `json:"unread"`
+}
+
+// get unread counts for every room shown to the user in the lobby
+func UnreadCounts(userID string) ([]UnreadCount, error) {
+	rows, err := postgres.GetUnreadCounts(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Error counting unread messages for %s: %w", userID, err)
+	}
+	counts := make([]UnreadCount, 0, len(rows))
+	for _, row := range rows {
+		counts = append(counts, UnreadCount{RoomID: row.RoomID, Unread: row.Unread})
+	}
+	return counts, nil
+}
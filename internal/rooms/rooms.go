@@ -0,0 +1,215 @@
+package rooms
+
+import (
+	"chatapp/internal/postgres"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Visibility string
+
+const (
+	Public  Visibility = "public"  // listed in the lobby and joinable by anyone
+	Private Visibility = "private" // hidden from the lobby
+)
+
+const (
+	maxNameLength  = 50
+	maxTopicLength = 200
+)
+
+var (
+	ErrNotFound  = errors.New("Room not found.")
+	ErrArchived  = errors.New("Room has been archived.")
+	ErrNotOwner  = errors.New("Only the room owner can do that.")
+	ErrNotMember = errors.New("This room is private.")
+)
+
+// a problem with room details supplied by a user
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// a room as returned by the REST endpoints
+type Room struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Topic      string     `json:"topic"`
+	OwnerID    string     `json:"owner_id,omitempty"`
+	Visibility Visibility `json:"visibility"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Archived   bool       `json:"archived"`
+}
+
+func fromRow(row postgres.Room) *Room {
+	return &Room{
+		ID:         row.ID,
+		Name:       row.Name,
+		Topic:      row.Topic,
+		OwnerID:    row.OwnerID,
+		Visibility: Visibility(row.Visibility),
+		CreatedAt:  row.CreatedAt,
+		Archived:   row.ArchivedAt.Valid,
+	}
+}
+
+// create a new room owned by a user
+func Create(ownerID, name, topic string, visibility Visibility) (*Room, error) {
+	name, topic, err := validateDetails(name, topic)
+	if err != nil {
+		return nil, err
+	}
+	if visibility == "" {
+		visibility = Public
+	}
+	if visibility != Public && visibility != Private {
+		return nil, &ValidationError{"Visibility must be public or private."}
+	}
+	row, err := postgres.CreateRoom(name, topic, ownerID, string(visibility))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating room %s: %w", name, err)
+	}
+	return fromRow(row), nil
+}
+
+// get the details of a room the user can access, including archived rooms
+func Describe(userID, roomID string) (*Room, error) {
+	room, err := describe(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(room, userID); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// get the details of a room without checking access
+func describe(roomID string) (*Room, error) {
+	row, err := postgres.GetRoom(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching room %s: %w", roomID, err)
+	}
+	return fromRow(row), nil
+}
+
+// list the rooms a user can join that haven't been archived, public rooms and private rooms they're a member of
+func List(userID string) ([]*Room, error) {
+	rows, err := postgres.ListRoomsForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing rooms: %w", err)
+	}
+	rooms := make([]*Room, 0, len(rows))
+	for _, row := range rows {
+		rooms = append(rooms, fromRow(row))
+	}
+	return rooms, nil
+}
+
+// rename a room and change its topic, nil leaves a detail unchanged, only the owner can update them
+// both details are validated before either is saved
+func Update(userID, roomID string, name, topic *string) (*Room, error) {
+	room, err := getOwnedActiveRoom(userID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		room.Name = *name
+	}
+	if topic != nil {
+		room.Topic = *topic
+	}
+	room.Name, room.Topic, err = validateDetails(room.Name, room.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := postgres.UpdateRoom(roomID, room.Name, room.Topic); err != nil {
+		return nil, fmt.Errorf("Error updating room %s: %w", roomID, err)
+	}
+	return room, nil
+}
+
+// archive a room so it can't be joined anymore, only the owner can archive it
+func Archive(userID, roomID string) error {
+	if _, err := getOwnedActiveRoom(userID, roomID); err != nil {
+		return err
+	}
+	if err := postgres.ArchiveRoom(roomID); err != nil {
+		return fmt.Errorf("Error archiving room %s: %w", roomID, err)
+	}
+	return nil
+}
+
+// returns nil if a room exists, hasn't been archived and the user is allowed in it and not banned
+func CheckJoinable(roomID, userID string) error {
+	room, err := Describe(userID, roomID)
+	if err != nil {
+		return err
+	}
+	if room.Archived {
+		return ErrArchived
+	}
+	return CheckNotBanned(roomID, userID)
+}
+
+// returns an error if the user can't read the rooms history, archived rooms stay readable but banned users can't
+// read a room they can't join
+func CheckReadable(roomID, userID string) error {
+	if _, err := Describe(userID, roomID); err != nil {
+		return err
+	}
+	return CheckNotBanned(roomID, userID)
+}
+
+// anyone can access a public room, private rooms are only for members
+func checkAccess(room *Room, userID string) error {
+	if room.Visibility == Public {
+		return nil
+	}
+	isMember, err := postgres.IsRoomMember(room.ID, userID)
+	if err != nil {
+		return fmt.Errorf("Error checking membership of room %s: %w", room.ID, err)
+	}
+	if !isMember {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// get a room that the user owns and is still active
+func getOwnedActiveRoom(userID, roomID string) (*Room, error) {
+	room, err := describe(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Archived {
+		return nil, ErrArchived
+	}
+	if room.OwnerID != userID {
+		return nil, ErrNotOwner
+	}
+	return room, nil
+}
+
+// trims and checks the length of a rooms name and topic
+func validateDetails(name, topic string) (string, string, error) {
+	name = strings.TrimSpace(name)
+	topic = strings.TrimSpace(topic)
+	if len(name) < 1 || len(name) > maxNameLength {
+		return "", "", &ValidationError{fmt.Sprintf("Room name must be between 1 and %d characters.", maxNameLength)}
+	}
+	if len(topic) > maxTopicLength {
+		return "", "", &ValidationError{fmt.Sprintf("Room topic must be at most %d characters.", maxTopicLength)}
+	}
+	return name, topic, nil
+}
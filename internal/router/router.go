@@ -2,21 +2,27 @@ package router
 
 import (
 	"chatapp/internal/chat"
+	"chatapp/internal/config"
 	"chatapp/internal/handlers"
 	"chatapp/internal/middleware"
+	"expvar"
 	"net/http"
 	"path/filepath"
 
 	"github.com/go-chi/chi/v5"
 )
 
-// create a router for server
-func NewRouter() http.Handler {
+// create a router for server, hub serves the websocket connections and is run by the caller
+func NewRouter(hub *chat.Hub) http.Handler {
 	router := chi.NewRouter()
 	registerAuthRoutes(router)
-	registerWsRoutes(router)
+	registerWsRoutes(router, hub)
+	registerRoomRoutes(router)
 	registerHTMLRoutes(router)
 	registerStaticRoutes(router)
+	if config.App.DebugVars {
+		router.Handle("/debug/vars", expvar.Handler()) // metrics, only enable where the port isn't public
+	}
 	return router
 }
 
@@ -42,12 +48,33 @@ func registerAuthRoutes(r chi.Router) {
 }
 
 // register websocket routes for chat messages
-func registerWsRoutes(r chi.Router) {
-	hub := chat.NewHub()
+func registerWsRoutes(r chi.Router, hub *chat.Hub) {
 	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		handlers.ServeWsConn(hub, w, r)
 	})
-	go hub.Run() // have hub running on its own thread
+}
+
+// register REST routes for rooms, their stored messages and invitations
+func registerRoomRoutes(r chi.Router) {
+	r.Group(func(sub chi.Router) {
+		sub.Use(middleware.AuthenticateAccessToken, middleware.NoCache)
+		sub.Get("/rooms", handlers.ListRoomsHandler)
+		sub.Post("/rooms", handlers.CreateRoomHandler)
+		sub.Get("/rooms/unread", handlers.UnreadCountsHandler)
+		sub.Get("/rooms/{roomID}", handlers.GetRoomHandler)
+		sub.Patch("/rooms/{roomID}", handlers.UpdateRoomHandler)
+		sub.Post("/rooms/{roomID}/archive", handlers.ArchiveRoomHandler)
+		sub.Post("/rooms/{roomID}/roles", handlers.SetRoomRoleHandler)
+		sub.Get("/rooms/{roomID}/messages", handlers.GetRoomMessagesHandler)
+		sub.Get("/rooms/{roomID}/messages/{messageID}/thread", handlers.GetThreadHandler)
+		sub.Post("/rooms/{roomID}/invites", handlers.InviteUserHandler)
+		sub.Post("/rooms/{roomID}/invite-link", handlers.CreateInviteLinkHandler)
+
+		sub.Get("/invites", handlers.ListInvitesHandler)
+		sub.Post("/invites/link", handlers.AcceptInviteLinkHandler)
+		sub.Post("/invites/{inviteID}/accept", handlers.AcceptInviteHandler)
+		sub.Post("/invites/{inviteID}/decline", handlers.DeclineInviteHandler)
+	})
 }
 
 // register routes for serving static frontend content
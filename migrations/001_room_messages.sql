@@ -0,0 +1,7 @@
+-- Store room chat messages: receiver is only set for direct messages, room_id for room messages
+ALTER TABLE messages ALTER COLUMN receiver_id DROP NOT NULL;
+ALTER TABLE messages ADD COLUMN room_id TEXT;
+ALTER TABLE messages ALTER COLUMN created_at SET NOT NULL;
+ALTER TABLE messages ADD CONSTRAINT chk_destination CHECK (room_id IS NOT NULL OR receiver_id IS NOT NULL);
+
+CREATE INDEX idx_messages_room_created ON messages (room_id, created_at DESC, id DESC);
@@ -0,0 +1,4 @@
+-- Track delivery of direct messages so receivers get messages sent while they were offline
+ALTER TABLE messages ADD COLUMN delivered_at TIMESTAMPTZ;
+
+CREATE INDEX idx_messages_undelivered ON messages (receiver_id, created_at) WHERE receiver_id IS NOT NULL AND delivered_at IS NULL;
@@ -0,0 +1,19 @@
+-- Registry of rooms, previously rooms were ad-hoc strings from the room_id query parameter
+CREATE TABLE rooms (
+    id TEXT PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    name TEXT NOT NULL,
+    topic TEXT NOT NULL DEFAULT '',
+    owner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    visibility TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'private')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMPTZ
+);
+
+INSERT INTO rooms (id, name, topic) VALUES ('1', 'Lobby', 'General chat for everyone');
+
+-- Keep history of ad-hoc rooms that already have messages by registering them as public rooms
+INSERT INTO rooms (id, name)
+SELECT DISTINCT room_id, 'Room ' || room_id FROM messages WHERE room_id IS NOT NULL
+ON CONFLICT (id) DO NOTHING;
+
+ALTER TABLE messages ADD CONSTRAINT fk_room FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE;
@@ -0,0 +1,4 @@
+-- Client supplied message IDs so retransmitted messages are only stored and broadcast once
+ALTER TABLE messages ADD COLUMN client_msg_id TEXT;
+
+CREATE UNIQUE INDEX idx_messages_client_msg_id ON messages (sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Threaded replies, replies point at the message that started the thread which keeps a reply count
+ALTER TABLE messages ADD COLUMN parent_message_id UUID;
+ALTER TABLE messages ADD COLUMN reply_count INT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN last_reply_at TIMESTAMPTZ;
+ALTER TABLE messages ADD CONSTRAINT fk_parent FOREIGN KEY (parent_message_id) REFERENCES messages(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_messages_parent_created ON messages (parent_message_id, created_at) WHERE parent_message_id IS NOT NULL;
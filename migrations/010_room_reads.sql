@@ -0,0 +1,9 @@
+-- The last message each user has read in each room, used for read receipts and unread counts
+CREATE TABLE room_reads (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    read_at TIMESTAMPTZ NOT NULL, -- created_at of message_id, messages after it are unread
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
@@ -0,0 +1,8 @@
+-- Broker messages too large for a NOTIFY payload, listeners load them by id and old rows are cleaned up on insert
+CREATE TABLE broker_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_broker_payloads_created ON broker_payloads (created_at);
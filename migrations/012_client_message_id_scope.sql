@@ -0,0 +1,5 @@
+-- Client message IDs are only unique per room or direct message receiver, so reusing one elsewhere isn't a duplicate
+-- NULLS NOT DISTINCT needs PostgreSQL 15, room_id is null for direct messages and receiver_id for room messages
+DROP INDEX idx_messages_client_msg_id;
+
+CREATE UNIQUE INDEX idx_messages_client_msg_id ON messages (sender_id, room_id, receiver_id, client_msg_id) NULLS NOT DISTINCT WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,423 @@
+// Package client is a Go client for the chat websocket protocol, for bots and integration tests.
+//
+// Dial connects to /ws, says hello and waits for the welcome. Every frame the server sends is delivered on Events
+// with its payload decoded into the struct from protocol.PayloadTypes, and the send methods cover every inbound message
+// type. Pings are answered automatically, and with Reconnect set a dropped connection is reopened and resumed from
+// the last message seen in each room. An expired session is refreshed through the Jar from Login, and reconnecting
+// stops with a HandshakeError once the server refuses the handshake, e.g. after a ban.
+package client
+
+import (
+	"chatapp/pkg/protocol"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrClosed       = errors.New("Client is closed.")
+	ErrNotConnected = errors.New("Client is reconnecting.")
+)
+
+const (
+	// the server pings every 54 seconds, a connection without one for this long is treated as dropped
+	pingTimeout = 70 * time.Second
+	// time allowed to write a message to the server
+	writeWait = 10 * time.Second
+	// reconnect attempts back off from the first delay up to the max
+	reconnectDelay    = time.Second
+	maxReconnectDelay = 30 * time.Second
+	// default size of the Events buffer, the server disconnects clients that fall too far behind
+	defaultEventBuffer = 256
+)
+
+type Options struct {
+	URL    string // websocket URL of the server, e.g. ws://localhost:8080/ws
+	RoomID string // room joined when connecting, more can be joined with Join
+
+	// authentication, either the value of the access_token cookie or a jar with the cookies from Login
+	AccessToken string
+	Jar         http.CookieJar
+
+	Header    http.Header // extra handshake headers, e.g. Origin
+	Features  []string    // optional frames to receive, see protocol.HelloData, nil asks for all of them
+	Reconnect bool        // reopen the connection and resume after it drops or the server restarts
+
+	EventBuffer int // size of the Events buffer, defaults to 256
+}
+
+// returned by Dial, and by Err once reconnecting stopped, when the server answered the handshake with an HTTP error
+// statuses from 400 to 499 such as an expired session or a banned room won't change on retry, so Reconnect gives up
+type HandshakeError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("Error connecting to %s (%s): %v", e.URL, e.Status, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+// returns true if retrying the request that failed with err can't succeed
+func refused(err error) bool {
+	var handshakeErr *HandshakeError
+	return errors.As(err, &handshakeErr) && handshakeErr.StatusCode >= 400 && handshakeErr.StatusCode < 500
+}
+
+// a frame received from the server
+type Event struct {
+	Type    protocol.MessageType
+	Payload any             // pointer to the types payload struct from protocol.PayloadTypes, e.g. *protocol.ChatMessageData
+	Raw     json.RawMessage // the undecoded payload, the only payload of types this client doesn't know
+}
+
+type Client struct {
+	opts   Options
+	dialer *websocket.Dialer
+	events chan Event
+
+	mu      sync.Mutex
+	conn    *websocket.Conn // nil while reconnecting
+	welcome *protocol.WelcomeData
+	rooms   map[string]string // Key: RoomID of a joined room, Value: newest message ID seen in it
+	err     error             // why Events was closed
+
+	writeMu sync.Mutex // a websocket connection supports one writer at a time
+
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// connects to the server and waits for its welcome, frames sent before the welcome are kept for Events
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	if opts.EventBuffer <= 0 {
+		opts.EventBuffer = defaultEventBuffer
+	}
+	c := &Client{
+		opts: opts,
+		dialer: &websocket.Dialer{
+			Proxy:             http.ProxyFromEnvironment,
+			HandshakeTimeout:  45 * time.Second,
+			Subprotocols:      []string{protocol.JSONSubprotocol},
+			EnableCompression: true,
+			Jar:               opts.Jar,
+		},
+		events:  make(chan Event, opts.EventBuffer),
+		rooms:   make(map[string]string),
+		closing: make(chan struct{}),
+	}
+	if opts.RoomID != "" {
+		c.rooms[opts.RoomID] = ""
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop(conn)
+	return c, nil
+}
+
+// frames from the server in the order they were sent, closed once the client is closed or the connection is lost
+// for good, see Err
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// the welcome from the current connection, with the users ID, limits and enabled features
+func (c *Client) Welcome() *protocol.WelcomeData {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome
+}
+
+// why Events was closed, ErrClosed after Close
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// closes the connection with a normal close frame, Events is closed once the read loop stops
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	return conn.Close()
+}
+
+// opens a connection resuming the rooms the client is in, says hello and reads until the welcome
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	c.mu.Lock()
+	query := url.Values{}
+	lastSeen, inInitialRoom := c.rooms[c.opts.RoomID]
+	if c.opts.RoomID != "" && inInitialRoom {
+		query.Set("room_id", c.opts.RoomID)
+		if lastSeen != "" {
+			query.Set("last_seen_message_id", lastSeen)
+		}
+	}
+	rejoin := make(map[string]string)
+	for roomID, lastSeen := range c.rooms {
+		if roomID != c.opts.RoomID {
+			rejoin[roomID] = lastSeen
+		}
+	}
+	c.mu.Unlock()
+
+	target, err := url.Parse(c.opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL: %w", err)
+	}
+	target.RawQuery = query.Encode()
+	header := c.opts.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.opts.AccessToken != "" {
+		header.Add("Cookie", (&http.Cookie{Name: "access_token", Value: c.opts.AccessToken}).String())
+	}
+	conn, resp, err := c.dialer.DialContext(ctx, target.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, &HandshakeError{URL: c.opts.URL, StatusCode: resp.StatusCode, Status: resp.Status, Err: err}
+		}
+		return nil, fmt.Errorf("Error connecting to %s: %w", c.opts.URL, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(pingTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(pingTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	if err := c.handshake(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	for roomID, lastSeen := range rejoin {
+		if _, err := c.Send(protocol.JoinRoom, protocol.RoomMembershipData{RoomID: roomID, LastSeenMessageID: lastSeen}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// sends the hello and reads frames until the welcome, the connection is closed if ctx ends first
+func (c *Client) handshake(ctx context.Context, conn *websocket.Conn) error {
+	features := c.opts.Features
+	if features == nil {
+		features = protocol.Features()
+	}
+	hello, err := encode(protocol.Hello, "", protocol.HelloData{
+		ProtocolVersion: protocol.ProtocolVersion,
+		Features:        features,
+		Client:          "go-client",
+	})
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, hello); err != nil {
+		return fmt.Errorf("Error sending hello: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	for {
+		event, err := readEvent(conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("Error waiting for welcome: %w", err)
+		}
+		switch payload := event.Payload.(type) {
+		case *protocol.WelcomeData:
+			c.mu.Lock()
+			c.welcome = payload
+			c.mu.Unlock()
+			c.deliver(event)
+			return nil
+		case *protocol.ErrorData:
+			if payload.Code == protocol.CodeUnsupportedVersion {
+				return errors.New(payload.Message)
+			}
+		}
+		c.deliver(event)
+	}
+}
+
+// reads frames onto Events until the connection is lost, then reconnects if the client was asked to
+func (c *Client) readLoop(conn *websocket.Conn) {
+	for {
+		event, err := readEvent(conn)
+		if err == nil {
+			c.deliver(event)
+			continue
+		}
+		conn.Close()
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		select {
+		case <-c.closing:
+			c.stop(ErrClosed)
+			return
+		default:
+		}
+		if !c.opts.Reconnect || !resumable(err) {
+			c.stop(fmt.Errorf("Connection lost: %w", err))
+			return
+		}
+		if conn, err = c.reconnect(); err != nil {
+			c.stop(err)
+			return
+		}
+	}
+}
+
+// keeps trying to connect with backoff until it succeeds, the client is closed or the server refuses the handshake
+// a refused handshake is returned, except a 401 from an expired access token is retried once after refreshing the
+// session through Options.Jar
+func (c *Client) reconnect() (*websocket.Conn, error) {
+	delay := reconnectDelay
+	refreshed := false
+	for {
+		select {
+		case <-c.closing:
+			return nil, ErrClosed
+		case <-time.After(delay):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.dialer.HandshakeTimeout)
+		conn, err := c.connect(ctx)
+		var handshakeErr *HandshakeError
+		if errors.As(err, &handshakeErr) && handshakeErr.StatusCode == http.StatusUnauthorized && c.opts.Jar != nil && !refreshed {
+			refreshed = true
+			if err = refresh(ctx, c.opts.Jar, c.opts.URL); err == nil {
+				conn, err = c.connect(ctx)
+			}
+		}
+		cancel()
+		if err == nil {
+			select {
+			case <-c.closing: // closed while connecting, Close didn't see this connection
+				conn.Close()
+				return nil, ErrClosed
+			default:
+				return conn, nil
+			}
+		}
+		if refused(err) {
+			return nil, err
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// closes Events, recording why
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.events)
+}
+
+// tracks the rooms the client is in and the newest message seen in each so a reconnect can resume them, then hands
+// the event to the caller
+func (c *Client) deliver(event Event) {
+	c.mu.Lock()
+	switch payload := event.Payload.(type) {
+	case *protocol.RoomMembershipData:
+		if _, ok := c.rooms[payload.RoomID]; !ok && event.Type == protocol.JoinRoom {
+			c.rooms[payload.RoomID] = "" // rejoins after a reconnect keep the message they resume from
+		} else if event.Type == protocol.LeaveRoom {
+			delete(c.rooms, payload.RoomID)
+		}
+	case *protocol.ChatMessageData:
+		if _, ok := c.rooms[payload.RoomID]; ok && event.Type == protocol.Chat && payload.MessageID != "" {
+			c.rooms[payload.RoomID] = payload.MessageID
+		}
+	}
+	c.mu.Unlock()
+	select {
+	case c.events <- event:
+	case <-c.closing:
+	}
+}
+
+// reads a frame and decodes its payload
+func readEvent(conn *websocket.Conn) (Event, error) {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return Event{}, err
+	}
+	var wsMessage protocol.WebSocketMessage
+	if err := json.Unmarshal(data, &wsMessage); err != nil {
+		return Event{}, fmt.Errorf("Invalid frame from server: %w", err)
+	}
+	event := Event{Type: wsMessage.Type, Raw: wsMessage.Payload}
+	if payloadType, ok := protocol.PayloadTypes[wsMessage.Type]; ok {
+		payload := reflect.New(payloadType).Interface()
+		if err := json.Unmarshal(wsMessage.Payload, payload); err != nil {
+			return Event{}, fmt.Errorf("Invalid %s payload from server: %w", wsMessage.Type, err)
+		}
+		event.Payload = payload
+	}
+	return event, nil
+}
+
+// returns true if a connection that ended with err should be reopened
+// kicks, bans, rate limit disconnects and unsupported versions would only happen again
+func resumable(err error) bool {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return true // dropped without a close frame
+	}
+	switch closeErr.Code {
+	case websocket.CloseServiceRestart, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, protocol.CloseSlowConsumer:
+		return true
+	}
+	return false
+}
+
+// encodes an outbound message with the payload inline
+func encode(messageType protocol.MessageType, id string, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling %s payload: %w", messageType, err)
+	}
+	return json.Marshal(protocol.WebSocketMessage{Type: messageType, ID: id, Payload: data})
+}
+
+// random correlation or client message ID, rand.Read never returns an error since Go 1.24
+func newID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
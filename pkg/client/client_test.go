@@ -0,0 +1,413 @@
+package client
+
+import (
+	"chatapp/pkg/protocol"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// a connection the fake server accepted, with the hello the client sent on it
+type serverConn struct {
+	query url.Values
+	hello *protocol.HelloData
+	conn  *websocket.Conn
+}
+
+// a websocket server that reads each clients hello and hands the connection to the test
+func newServer(t *testing.T) (string, <-chan *serverConn) {
+	t.Helper()
+	conns := make(chan *serverConn, 4)
+	server := httptest.NewServer(wsHandler(t, conns))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), conns
+}
+
+// upgrades a request, reads the hello and hands the connection to the test
+func wsHandler(t *testing.T, conns chan<- *serverConn) http.HandlerFunc {
+	upgrader := websocket.Upgrader{Subprotocols: []string{protocol.JSONSubprotocol}}
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var wsMessage protocol.WebSocketMessage
+		if err := conn.ReadJSON(&wsMessage); err != nil || wsMessage.Type != protocol.Hello {
+			t.Errorf("got %q frame, want a hello: %v", wsMessage.Type, err)
+			conn.Close()
+			return
+		}
+		var hello protocol.HelloData
+		if err := json.Unmarshal(wsMessage.Payload, &hello); err != nil {
+			t.Error(err)
+		}
+		conns <- &serverConn{r.URL.Query(), &hello, conn}
+	}
+}
+
+// sends a frame from the fake server
+func send(t *testing.T, conn *websocket.Conn, messageType protocol.MessageType, payload any) {
+	t.Helper()
+	data, err := encode(messageType, "", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the next connection the fake server accepted, fails the test if none arrives
+func accept(t *testing.T, conns <-chan *serverConn) *serverConn {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		t.Cleanup(func() { conn.conn.Close() })
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("client didn't connect")
+		return nil
+	}
+}
+
+// the next event, fails the test if none arrives
+func next(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case event, ok := <-c.Events():
+		if !ok {
+			t.Fatalf("events closed: %v", c.Err())
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+// dials the fake server, answering the hello with a welcome for the given user
+func dial(t *testing.T, wsURL string, conns <-chan *serverConn, opts Options, userID string) (*Client, *serverConn) {
+	t.Helper()
+	opts.URL = wsURL
+	dialed := make(chan *Client)
+	go func() {
+		c, err := Dial(context.Background(), opts)
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- c
+	}()
+	server := accept(t, conns)
+	send(t, server.conn, protocol.Welcome, protocol.WelcomeData{ProtocolVersion: protocol.ProtocolVersion, UserID: userID, Features: server.hello.Features})
+	c := <-dialed
+	if c == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, server
+}
+
+func TestDialSaysHelloAndWaitsForWelcome(t *testing.T) {
+	wsURL, conns := newServer(t)
+	c, server := dial(t, wsURL, conns, Options{RoomID: "general", Features: []string{"typing"}}, "user-1")
+
+	if server.hello.ProtocolVersion != protocol.ProtocolVersion || !slices.Equal(server.hello.Features, []string{"typing"}) {
+		t.Errorf("got hello %+v", server.hello)
+	}
+	if server.query.Get("room_id") != "general" || server.query.Has("last_seen_message_id") {
+		t.Errorf("got query %v", server.query)
+	}
+	if welcome := c.Welcome(); welcome == nil || welcome.UserID != "user-1" {
+		t.Fatalf("got welcome %+v", welcome)
+	}
+	if event := next(t, c); event.Type != protocol.Welcome {
+		t.Errorf("got %s event, want the welcome", event.Type)
+	}
+}
+
+func TestDialAsksForAllFeaturesByDefault(t *testing.T) {
+	wsURL, conns := newServer(t)
+	_, server := dial(t, wsURL, conns, Options{}, "user-1")
+	if !slices.Equal(server.hello.Features, protocol.Features()) {
+		t.Errorf("got features %v, want %v", server.hello.Features, protocol.Features())
+	}
+}
+
+func TestDialFailsOnUnsupportedVersion(t *testing.T) {
+	wsURL, conns := newServer(t)
+	go func() {
+		server := <-conns
+		defer server.conn.Close()
+		data, _ := encode(protocol.Error, "", protocol.ErrorData{Code: protocol.CodeUnsupportedVersion, Message: "Protocol version 1 isn't supported."})
+		server.conn.WriteMessage(websocket.TextMessage, data)
+		server.conn.ReadMessage() // until the client hangs up
+	}()
+	_, err := Dial(context.Background(), Options{URL: wsURL})
+	if err == nil || err.Error() != "Protocol version 1 isn't supported." {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestEventsHaveTypedPayloads(t *testing.T) {
+	wsURL, conns := newServer(t)
+	c, server := dial(t, wsURL, conns, Options{RoomID: "general"}, "user-1")
+	next(t, c) // the welcome
+
+	// every known type is decoded into its payload struct
+	for messageType, payloadType := range protocol.PayloadTypes {
+		send(t, server.conn, messageType, map[string]any{})
+		event := next(t, c)
+		if event.Type != messageType {
+			t.Fatalf("got %s event, want %s", event.Type, messageType)
+		}
+		if got := reflect.TypeOf(event.Payload); got != reflect.PointerTo(payloadType) {
+			t.Errorf("%s payload is %v, want *%s", messageType, got, payloadType)
+		}
+	}
+
+	sent := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+	send(t, server.conn, protocol.Chat, protocol.ChatMessageData{MessageID: "m1", RoomID: "general", Text: "hi", Time: sent})
+	chat, ok := next(t, c).Payload.(*protocol.ChatMessageData)
+	if !ok || chat.Text != "hi" || !chat.Time.Equal(sent) {
+		t.Errorf("got chat %+v", chat)
+	}
+
+	// types this client doesn't know only have the raw payload
+	send(t, server.conn, "from_the_future", map[string]any{"field": 1})
+	event := next(t, c)
+	if event.Payload != nil || string(event.Raw) != `{"field":1}` {
+		t.Errorf("got unknown event %+v with raw %s", event.Payload, event.Raw)
+	}
+}
+
+func TestReconnectResumesAfterRestart(t *testing.T) {
+	wsURL, conns := newServer(t)
+	c, server := dial(t, wsURL, conns, Options{RoomID: "general", Reconnect: true}, "user-1")
+	next(t, c) // the welcome
+
+	send(t, server.conn, protocol.JoinRoom, protocol.RoomMembershipData{RoomID: "random"})
+	send(t, server.conn, protocol.Chat, protocol.ChatMessageData{MessageID: "m1", RoomID: "general", Text: "first"})
+	send(t, server.conn, protocol.Chat, protocol.ChatMessageData{MessageID: "m2", RoomID: "general", Text: "second"})
+	send(t, server.conn, protocol.Chat, protocol.ChatMessageData{MessageID: "r1", RoomID: "random", Text: "elsewhere"})
+	for range 4 {
+		next(t, c)
+	}
+	server.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, "Server is restarting."))
+	server.conn.Close()
+
+	resumed := accept(t, conns)
+	if resumed.query.Get("room_id") != "general" || resumed.query.Get("last_seen_message_id") != "m2" {
+		t.Errorf("reconnected with query %v, want general from m2", resumed.query)
+	}
+	send(t, resumed.conn, protocol.Welcome, protocol.WelcomeData{ProtocolVersion: protocol.ProtocolVersion, UserID: "user-1"})
+	if event := next(t, c); event.Type != protocol.Welcome {
+		t.Fatalf("got %s event after reconnecting, want the welcome", event.Type)
+	}
+
+	// other rooms are rejoined from the last message seen in them
+	var wsMessage protocol.WebSocketMessage
+	resumed.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := resumed.conn.ReadJSON(&wsMessage); err != nil {
+		t.Fatal(err)
+	}
+	var join protocol.RoomMembershipData
+	json.Unmarshal(wsMessage.Payload, &join)
+	if wsMessage.Type != protocol.JoinRoom || join.RoomID != "random" || join.LastSeenMessageID != "r1" {
+		t.Errorf("got %s %+v, want a join of random from r1", wsMessage.Type, join)
+	}
+}
+
+func TestKickedClientDoesNotReconnect(t *testing.T) {
+	wsURL, conns := newServer(t)
+	c, server := dial(t, wsURL, conns, Options{RoomID: "general", Reconnect: true}, "user-1")
+	next(t, c) // the welcome
+
+	server.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Kicked from the room."))
+	select {
+	case _, ok := <-c.Events():
+		if ok {
+			t.Fatal("got an event after the kick")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events weren't closed")
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(c.Err(), &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("got error %v", c.Err())
+	}
+}
+
+func TestSendMethodsReturnTheCorrelationID(t *testing.T) {
+	wsURL, conns := newServer(t)
+	c, server := dial(t, wsURL, conns, Options{RoomID: "general"}, "user-1")
+
+	read := func() (*protocol.WebSocketMessage, map[string]any) {
+		t.Helper()
+		var wsMessage protocol.WebSocketMessage
+		server.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := server.conn.ReadJSON(&wsMessage); err != nil {
+			t.Fatal(err)
+		}
+		var payload map[string]any
+		json.Unmarshal(wsMessage.Payload, &payload)
+		return &wsMessage, payload
+	}
+
+	// chat messages are rejected and acknowledged by the same ID
+	id, err := c.SendChat("", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsMessage, payload := read()
+	if wsMessage.ID != id || payload["client_msg_id"] != id {
+		t.Errorf("SendChat returned %s, sent id %s and client_msg_id %v", id, wsMessage.ID, payload["client_msg_id"])
+	}
+
+	id, err = c.Mute("general", "user-2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wsMessage, payload := read(); wsMessage.ID != id || payload["duration_seconds"] != 60.0 {
+		t.Errorf("Mute returned %s, sent id %s with %v", id, wsMessage.ID, payload)
+	}
+
+	if _, err = c.StartTyping(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, payload := read(); len(payload) != 0 {
+		t.Errorf("typing_start sent %v, want an empty payload", payload)
+	}
+}
+
+// the server restarting the connection, the client reconnects after reconnectDelay
+func restart(server *serverConn) {
+	server.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, "Server is restarting."))
+	server.conn.Close()
+}
+
+// waits for Events to be closed, fails the test if it stays open
+func waitStopped(t *testing.T, c *Client) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-c.Events():
+			if !ok {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("events weren't closed")
+		}
+	}
+}
+
+func TestReconnectStopsWhenHandshakeIsRefused(t *testing.T) {
+	conns := make(chan *serverConn, 4)
+	var refuse atomic.Bool
+	var handshakes atomic.Int32
+	ws := wsHandler(t, conns)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes.Add(1)
+		if refuse.Load() {
+			http.Error(w, "You are banned from this room.", http.StatusForbidden)
+			return
+		}
+		ws(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	c, conn := dial(t, "ws"+strings.TrimPrefix(server.URL, "http"), conns, Options{RoomID: "general", Reconnect: true}, "user-1")
+	refuse.Store(true)
+	restart(conn)
+	waitStopped(t, c)
+
+	var handshakeErr *HandshakeError
+	if !errors.As(c.Err(), &handshakeErr) || handshakeErr.StatusCode != http.StatusForbidden {
+		t.Errorf("got error %v, want the refused handshake", c.Err())
+	}
+	if n := handshakes.Load(); n != 2 {
+		t.Errorf("got %d handshakes, want the first and one refused retry", n)
+	}
+}
+
+func TestReconnectRefreshesExpiredSession(t *testing.T) {
+	tests := []struct {
+		name          string
+		refreshStatus int
+		wantResumed   bool
+	}{
+		{"refreshed session resumes", http.StatusOK, true},
+		{"expired refresh token stops", http.StatusUnauthorized, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conns := make(chan *serverConn, 4)
+			var accessToken atomic.Value // the only access token the server accepts
+			accessToken.Store("first")
+			var refreshes atomic.Int32
+			ws := wsHandler(t, conns)
+			mux := http.NewServeMux()
+			mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+				if cookie, err := r.Cookie("access_token"); err != nil || cookie.Value != accessToken.Load() {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				ws(w, r)
+			})
+			mux.HandleFunc("POST /auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+				refreshes.Add(1)
+				if cookie, err := r.Cookie("refresh_token"); err != nil || cookie.Value != "refresh" {
+					http.Error(w, "Missing refresh_token cookie.", http.StatusBadRequest)
+					return
+				}
+				if test.refreshStatus != http.StatusOK {
+					http.Error(w, "Refresh token has expired", test.refreshStatus)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "second", Path: "/"})
+			})
+			server := httptest.NewServer(mux)
+			t.Cleanup(server.Close)
+
+			jar, _ := cookiejar.New(nil)
+			serverURL, _ := url.Parse(server.URL)
+			jar.SetCookies(serverURL, []*http.Cookie{
+				{Name: "access_token", Value: "first", Path: "/"},
+				{Name: "refresh_token", Value: "refresh", Path: "/"},
+			})
+			c, conn := dial(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/ws", conns, Options{RoomID: "general", Jar: jar, Reconnect: true}, "user-1")
+			next(t, c) // the welcome
+
+			accessToken.Store("second") // the first access token expired
+			restart(conn)
+			if test.wantResumed {
+				resumed := accept(t, conns)
+				send(t, resumed.conn, protocol.Welcome, protocol.WelcomeData{ProtocolVersion: protocol.ProtocolVersion, UserID: "user-1"})
+				if event := next(t, c); event.Type != protocol.Welcome {
+					t.Fatalf("got %s event after reconnecting, want the welcome", event.Type)
+				}
+			} else {
+				waitStopped(t, c)
+				var handshakeErr *HandshakeError
+				if !errors.As(c.Err(), &handshakeErr) || handshakeErr.StatusCode != test.refreshStatus {
+					t.Errorf("got error %v, want the refused refresh", c.Err())
+				}
+			}
+			if n := refreshes.Load(); n != 1 {
+				t.Errorf("refreshed %d times, want once", n)
+			}
+		})
+	}
+}
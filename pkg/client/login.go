@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// signs in with an email and password, returns a jar with the session cookies to pass as Options.Jar
+// baseURL is the servers HTTP address, e.g. http://localhost:8080
+func Login(ctx context.Context, baseURL string, email string, password string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"email": {email}, "password": {password}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/auth/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := (&http.Client{Jar: jar}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error logging in: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("Login failed (%s): %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return jar, nil
+}
+
+// gets new session cookies into the jar with the refresh token from Login, used to reconnect once the access token
+// has expired, serverURL is the websocket URL the client connects to
+// a refresh the server refuses is returned as a HandshakeError so reconnecting stops
+func refresh(ctx context.Context, jar http.CookieJar, serverURL string) error {
+	target, err := url.Parse(serverURL)
+	if err != nil {
+		return fmt.Errorf("Invalid server URL: %w", err)
+	}
+	switch target.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/ws") + "/auth/refresh"
+	target.RawQuery = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Jar: jar}).Do(req)
+	if err != nil {
+		return fmt.Errorf("Error refreshing session: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := errors.New(strings.TrimSpace(string(body)))
+		return &HandshakeError{URL: target.String(), StatusCode: resp.StatusCode, Status: resp.Status, Err: err}
+	}
+	return nil
+}
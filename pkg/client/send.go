@@ -0,0 +1,137 @@
+package client
+
+import (
+	"chatapp/pkg/protocol"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sends a message of any type, the payload is one of the structs from protocol.PayloadTypes
+// returns the correlation ID the server echoes back in an error frame if the message is rejected, the other send
+// methods return it too
+func (c *Client) Send(messageType protocol.MessageType, payload any) (string, error) {
+	return c.send(messageType, newID(), payload)
+}
+
+// sends a message with the given correlation ID
+func (c *Client) send(messageType protocol.MessageType, id string, payload any) (string, error) {
+	data, err := encode(messageType, id, payload)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		select {
+		case <-c.closing:
+			return "", ErrClosed
+		default:
+			return "", ErrNotConnected
+		}
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return "", fmt.Errorf("Error sending %s: %w", messageType, err)
+	}
+	return id, nil
+}
+
+// sends a chat message to a room, an empty roomID is the room the client connected to
+// returns the client message ID, which the server acknowledges it with and is also its correlation ID
+func (c *Client) SendChat(roomID string, text string) (string, error) {
+	return c.Reply(roomID, "", text)
+}
+
+// sends a chat message as a reply in the thread of parentMessageID
+func (c *Client) Reply(roomID string, parentMessageID string, text string) (string, error) {
+	clientMsgID := newID()
+	return c.send(protocol.Chat, clientMsgID, protocol.ChatMessageData{
+		RoomID:          roomID,
+		Text:            text,
+		ClientMsgID:     clientMsgID,
+		ParentMessageID: parentMessageID,
+	})
+}
+
+// sends a direct message to a user by ID, returns its client message ID like SendChat
+func (c *Client) SendDirect(receiverID string, text string) (string, error) {
+	clientMsgID := newID()
+	return c.send(protocol.DirectMessage, clientMsgID, protocol.ChatMessageData{
+		ReceiverID:  receiverID,
+		Text:        text,
+		ClientMsgID: clientMsgID,
+	})
+}
+
+func (c *Client) Edit(roomID string, messageID string, text string) (string, error) {
+	return c.Send(protocol.MessageEdit, protocol.MessageEditData{RoomID: roomID, MessageID: messageID, Text: text})
+}
+
+func (c *Client) Delete(roomID string, messageID string) (string, error) {
+	return c.Send(protocol.MessageDelete, protocol.MessageEditData{RoomID: roomID, MessageID: messageID})
+}
+
+// toggles the clients reaction to a message
+func (c *Client) React(roomID string, messageID string, emoji string) (string, error) {
+	return c.Send(protocol.React, protocol.ReactData{RoomID: roomID, MessageID: messageID, Emoji: emoji})
+}
+
+// shows the user typing in the room, the server stops showing it after a few seconds unless it is repeated
+func (c *Client) StartTyping(roomID string) (string, error) {
+	return c.Send(protocol.TypingStart, protocol.TypingUpdateData{RoomID: roomID})
+}
+
+func (c *Client) StopTyping(roomID string) (string, error) {
+	return c.Send(protocol.TypingStop, protocol.TypingUpdateData{RoomID: roomID})
+}
+
+// moves the users read position in the room forward to messageID
+func (c *Client) ReadUpTo(roomID string, messageID string) (string, error) {
+	return c.Send(protocol.ReadUpTo, protocol.ReadUpToData{RoomID: roomID, MessageID: messageID})
+}
+
+// sets the users status to away or dnd, or back to online
+func (c *Client) SetStatus(status protocol.Status) (string, error) {
+	return c.Send(protocol.Presence, protocol.PresenceData{Status: status})
+}
+
+func (c *Client) UpdateUsername(username string) (string, error) {
+	return c.Send(protocol.UsernameUpdate, protocol.UsernameUpdateData{Username: username})
+}
+
+// joins another room on this connection, it is rejoined after a reconnect until left
+func (c *Client) Join(roomID string) (string, error) {
+	return c.Send(protocol.JoinRoom, protocol.RoomMembershipData{RoomID: roomID})
+}
+
+func (c *Client) Leave(roomID string) (string, error) {
+	return c.Send(protocol.LeaveRoom, protocol.RoomMembershipData{RoomID: roomID})
+}
+
+// moderation of another user in the room, only allowed for owners and moderators
+func (c *Client) Kick(roomID string, userID string, reason string) (string, error) {
+	return c.Send(protocol.Kick, protocol.ModerationData{RoomID: roomID, UserID: userID, Reason: reason})
+}
+
+// bans a user from the room, a zero duration lasts until they are unbanned
+func (c *Client) Ban(roomID string, userID string, reason string, duration time.Duration) (string, error) {
+	return c.Send(protocol.Ban, protocol.ModerationData{RoomID: roomID, UserID: userID, Reason: reason, DurationSeconds: int(duration.Seconds())})
+}
+
+func (c *Client) Unban(roomID string, userID string) (string, error) {
+	return c.Send(protocol.Unban, protocol.ModerationData{RoomID: roomID, UserID: userID})
+}
+
+// stops a user sending chat messages in the room, a zero duration lasts until they are unmuted
+func (c *Client) Mute(roomID string, userID string, duration time.Duration) (string, error) {
+	return c.Send(protocol.Mute, protocol.ModerationData{RoomID: roomID, UserID: userID, DurationSeconds: int(duration.Seconds())})
+}
+
+func (c *Client) Unmute(roomID string, userID string) (string, error) {
+	return c.Send(protocol.Unmute, protocol.ModerationData{RoomID: roomID, UserID: userID})
+}
@@ -0,0 +1,19 @@
+package protocol
+
+// machine readable reason a client message was rejected
+type ErrorCode string
+
+const (
+	CodeMalformed          ErrorCode = "malformed"           // message isn't a valid WebSocketMessage
+	CodeMessageTooLarge    ErrorCode = "message_too_large"   // message is over the maximum frame size, or its text is too long
+	CodeInvalidText        ErrorCode = "invalid_text"        // message text is blank or contains control characters
+	CodeUnknownType        ErrorCode = "unknown_type"        // message type isn't supported
+	CodeBadPayload         ErrorCode = "bad_payload"         // payload couldn't be decoded or is missing fields
+	CodeNotFound           ErrorCode = "not_found"           // referenced user or message doesn't exist
+	CodePermissionDenied   ErrorCode = "permission_denied"   // sender isn't allowed to do this
+	CodeMuted              ErrorCode = "muted"               // sender is muted in the room
+	CodeNotJoined          ErrorCode = "not_joined"          // sender hasn't joined the room the message is for
+	CodeRateLimited        ErrorCode = "rate_limited"        // sender is sending messages too quickly
+	CodeUnsupportedVersion ErrorCode = "unsupported_version" // hello asked for a protocol version the server doesn't speak
+	CodeInternal           ErrorCode = "internal_error"      // server failed to process the message
+)
@@ -0,0 +1,11 @@
+package protocol
+
+import "regexp"
+
+// message, user and invite IDs are postgres generated UUIDs
+var idPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// returns true if id has the form of a server assigned ID, anything else can't match a stored row
+func IsID(id string) bool {
+	return idPattern.MatchString(id)
+}
@@ -0,0 +1,332 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+//go:generate go run ../../cmd/schemagen -o ../../docs/protocol.schema.json
+
+// -------------------------- WEB SOCKET MODELS -------------------------------------------
+
+// Instead of interpreting HTTP Methods and URL paths, we create our own custom protocol
+// by defining different types of websocket messages and payloads with JSON
+//
+// Clients start by sending a hello with the ProtocolVersion they speak, see protocol.go. Changes that would break
+// existing clients bump the version.
+//
+// A connection can be in several rooms. Inbound messages for a room name it with room_id in their payload,
+// messages without one go to the room the connection was opened with.
+
+type MessageType string
+
+const (
+	Chat           MessageType = "chat"            // (bidirectional) - receives messages from clients and broadcasts them
+	UsernameUpdate MessageType = "username_update" // (inbound) - updates the clients username and triggers a new userlist broadcast
+	UserList       MessageType = "userlist"        // (outbound) - updates active user lists with current connected clients
+	DirectMessage  MessageType = "direct_message"  // (bidirectional) - private message delivered only to the receivers connected clients
+	Kick           MessageType = "kick"            // (inbound) - moderators disconnect a user from the room
+	Ban            MessageType = "ban"             // (inbound) - moderators disconnect a user and stop them rejoining, optionally for a duration
+	Unban          MessageType = "unban"           // (inbound) - moderators lift a ban
+	Mute           MessageType = "mute"            // (inbound) - moderators stop a user sending chat messages, optionally for a duration
+	Unmute         MessageType = "unmute"          // (inbound) - moderators lift a mute
+	Error          MessageType = "error"           // (outbound) - tells a client why its message was rejected
+	Ack            MessageType = "ack"             // (outbound) - confirms a chat or direct message was stored
+	MessageEdit    MessageType = "message_edit"    // (bidirectional) - replaces the text of a stored room message
+	MessageDelete  MessageType = "message_delete"  // (bidirectional) - removes a stored room message
+	React          MessageType = "react"           // (inbound) - toggles the senders emoji reaction on a room message
+	ReactionUpdate MessageType = "reaction_update" // (outbound) - the new reaction counts on a message after a reaction was toggled
+	TypingStart    MessageType = "typing_start"    // (inbound) - the sender is typing, repeated every few seconds while they keep typing
+	TypingStop     MessageType = "typing_stop"     // (inbound) - the sender stopped typing or sent their message
+	Typing         MessageType = "typing"          // (outbound) - a user in the room started or stopped typing
+	ReadUpTo       MessageType = "read_up_to"      // (inbound) - records the last message the sender has read in their room
+	ReadReceipt    MessageType = "read_receipt"    // (outbound) - a user in the room read up to a message
+	Presence       MessageType = "presence"        // (bidirectional) - sets the senders status, and tells users sharing a room when a status changes
+	JoinRoom       MessageType = "join_room"       // (bidirectional) - subscribes the connection to another room, confirmed before the rooms history
+	LeaveRoom      MessageType = "leave_room"      // (bidirectional) - unsubscribes the connection from a room, also sent when removed by a moderator
+	Hello          MessageType = "hello"           // (inbound) - first message on a connection, the protocol version and features the client wants
+	Welcome        MessageType = "welcome"         // (outbound) - reply to hello, tells the client who it is, its limits and enabled features
+	Resume         MessageType = "resume"          // (outbound) - precedes the messages a reconnecting client missed in a room
+)
+
+const (
+	NotificationSenderID string = "notification" // SenderID used when Hub is sending notifications to a room
+)
+
+// the payload of every message type, used to generate the protocol schema and by the Go client in pkg/client
+var PayloadTypes = map[MessageType]reflect.Type{
+	Chat:           reflect.TypeFor[ChatMessageData](),
+	UsernameUpdate: reflect.TypeFor[UsernameUpdateData](),
+	UserList:       reflect.TypeFor[UserListMessage](),
+	DirectMessage:  reflect.TypeFor[ChatMessageData](),
+	Kick:           reflect.TypeFor[ModerationData](),
+	Ban:            reflect.TypeFor[ModerationData](),
+	Unban:          reflect.TypeFor[ModerationData](),
+	Mute:           reflect.TypeFor[ModerationData](),
+	Unmute:         reflect.TypeFor[ModerationData](),
+	Error:          reflect.TypeFor[ErrorData](),
+	Ack:            reflect.TypeFor[AckData](),
+	MessageEdit:    reflect.TypeFor[MessageEditData](),
+	MessageDelete:  reflect.TypeFor[MessageEditData](),
+	React:          reflect.TypeFor[ReactData](),
+	ReactionUpdate: reflect.TypeFor[ReactionUpdateData](),
+	TypingStart:    reflect.TypeFor[TypingUpdateData](),
+	TypingStop:     reflect.TypeFor[TypingUpdateData](),
+	Typing:         reflect.TypeFor[TypingData](),
+	ReadUpTo:       reflect.TypeFor[ReadUpToData](),
+	ReadReceipt:    reflect.TypeFor[ReadReceiptData](),
+	Presence:       reflect.TypeFor[PresenceData](),
+	JoinRoom:       reflect.TypeFor[RoomMembershipData](),
+	LeaveRoom:      reflect.TypeFor[RoomMembershipData](),
+	Hello:          reflect.TypeFor[HelloData](),
+	Welcome:        reflect.TypeFor[WelcomeData](),
+	Resume:         reflect.TypeFor[ResumeData](),
+}
+
+// if sending an outbound WebSocket message to peer, will be encoded into a JSON byte slice at the transport layer
+// if reading an inbound WebSocket message from peer, will be decoded into one of the structs below
+type WebSocketMessage struct {
+	Type    MessageType     `json:"type"`
+	ID      string          `json:"id,omitempty"` // optional client supplied correlation ID, echoed back in error frames
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Message Type: Chat
+// Direction: Bidirectional
+// Purpose: Inbound chat messages are added to Hub broadcast channel, then are sent outbound. Also used for chat notifications.
+type ChatMessageData struct {
+	MessageID        string         `json:"message_id,omitempty"`
+	ClientMsgID      string         `json:"client_msg_id,omitempty"` // set by the sender so retransmissions are only stored once
+	SenderID         string         `json:"sender_id,omitempty"`
+	SenderUsername   string         `json:"sender_username,omitempty"`
+	ReceiverID       string         `json:"receiver_id,omitempty"`
+	ReceiverUsername string         `json:"receiver_username,omitempty"`
+	RoomID           string         `json:"room_id,omitempty"`
+	Text             string         `json:"text"`
+	Time             time.Time      `json:"time,omitempty"`
+	EditedAt         *time.Time     `json:"edited_at,omitempty"`         // set on stored messages that were edited
+	Deleted          bool           `json:"deleted,omitempty"`           // deleted messages are sent in history with empty Text
+	Reactions        []Reaction     `json:"reactions,omitempty"`         // only set on stored messages loaded from history
+	ParentMessageID  string         `json:"parent_message_id,omitempty"` // set on thread replies, inbound replies must be to a message in the same room
+	ReplyCount       int            `json:"reply_count,omitempty"`       // replies in the thread started by this message
+	LastReplyAt      *time.Time     `json:"last_reply_at,omitempty"`
+	Thread           *ThreadSummary `json:"thread,omitempty"` // set on outbound replies so clients can update the parent
+}
+
+// the reply count and last reply time of a thread after a new reply
+type ThreadSummary struct {
+	ParentMessageID string    `json:"parent_message_id"`
+	ReplyCount      int       `json:"reply_count"`
+	LastReplyAt     time.Time `json:"last_reply_at"`
+}
+
+// the number of users who reacted to a message with an emoji
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted,omitempty"` // the user receiving the message is one of them, only set in history
+}
+
+// Message Type: DirectMessage
+// Direction: Bidirectional
+// Purpose: Uses the ChatMessageData payload. Inbound messages set Text and either ReceiverID or ReceiverUsername,
+// outbound messages are delivered to the receivers clients in every room and echoed to the senders clients.
+
+// Message Type: Kick, Ban, Unban, Mute, Unmute
+// Direction: Inbound
+// Purpose: Moderation of another user in the senders room, the target is given by UserID or Username.
+// Only owners and moderators can moderate, and only users with a lower role than their own.
+type ModerationData struct {
+	RoomID          string `json:"room_id,omitempty"`
+	UserID          string `json:"user_id,omitempty"`
+	Username        string `json:"username,omitempty"`
+	Reason          string `json:"reason,omitempty"`           // shown to kicked and banned users, not used by the other types
+	DurationSeconds int    `json:"duration_seconds,omitempty"` // ban and mute length up to a year, 0 lasts until lifted
+}
+
+// Message Type: Error
+// Direction: Outbound
+// Purpose: Sent only to the client whose message was rejected
+type ErrorData struct {
+	Code          ErrorCode `json:"code"`                     // machine readable reason, see ErrorCode
+	Message       string    `json:"message"`                  // human readable reason to show the user
+	CorrelationID string    `json:"correlation_id,omitempty"` // ID of the rejected WebSocketMessage if the client set one
+}
+
+// Message Type: Ack
+// Direction: Outbound
+// Purpose: Sent to the sender once a chat or direct message is stored, maps its client ID to the server message ID.
+// Retransmitting a message with the same client ID is acknowledged again with Duplicate set instead of being resent.
+type AckData struct {
+	ClientMsgID string    `json:"client_msg_id"`
+	MessageID   string    `json:"message_id"`
+	Time        time.Time `json:"time"`
+	Duplicate   bool      `json:"duplicate,omitempty"`
+}
+
+// Message Type: MessageEdit, MessageDelete
+// Direction: Bidirectional
+// Purpose: Inbound messages set MessageID, and Text for edits. Only the sender or a room moderator can edit or delete
+// a message. Outbound messages are broadcast to the room so clients can update the message in place.
+type MessageEditData struct {
+	MessageID string    `json:"message_id"`
+	RoomID    string    `json:"room_id,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	EditorID  string    `json:"editor_id,omitempty"`
+	Time      time.Time `json:"time,omitempty"` // when the message was edited or deleted
+}
+
+// Message Type: React
+// Direction: Inbound
+// Purpose: Adds the senders reaction to a message in their room, or removes it if they already reacted with that emoji
+type ReactData struct {
+	RoomID    string `json:"room_id,omitempty"`
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// Message Type: ReactionUpdate
+// Direction: Outbound
+// Purpose: Broadcast to the room when a user toggles a reaction, with the new totals for every emoji on the message.
+// Clients track their own reactions from history and the UserID of updates, Reacted is never set here.
+type ReactionUpdateData struct {
+	MessageID string     `json:"message_id"`
+	RoomID    string     `json:"room_id"`
+	UserID    string     `json:"user_id"`
+	Emoji     string     `json:"emoji"`
+	Added     bool       `json:"added"`
+	Reactions []Reaction `json:"reactions"`
+}
+
+// Message Type: TypingStart, TypingStop
+// Direction: Inbound
+// Purpose: Starts or stops the senders typing indicator in a room, the payload only needs RoomID and can be left out
+// for the initial room. The indicator stops on its own if typing_start isn't repeated within a few seconds.
+type TypingUpdateData struct {
+	RoomID string `json:"room_id,omitempty"`
+}
+
+// Message Type: Typing
+// Direction: Outbound
+// Purpose: Sent to everyone else in the room when a user starts or stops typing
+type TypingData struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	RoomID   string `json:"room_id"`
+	Typing   bool   `json:"typing"`
+}
+
+// Message Type: ReadUpTo
+// Direction: Inbound
+// Purpose: Moves the senders read position in their room forward to MessageID, used for unread counts
+type ReadUpToData struct {
+	RoomID    string `json:"room_id,omitempty"`
+	MessageID string `json:"message_id"`
+}
+
+// Message Type: ReadReceipt
+// Direction: Outbound
+// Purpose: Broadcast to the room when a users read position moves forward
+type ReadReceiptData struct {
+	RoomID    string    `json:"room_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username"`
+	MessageID string    `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"` // time the read message was sent
+}
+
+// Message Type: Presence
+// Direction: Bidirectional
+// Purpose: Inbound messages set Status to away or dnd, or back to online to follow activity again.
+// Outbound messages are sent to the clients of every user sharing a room with UserID when their status changes,
+// including going away after being idle and offline when their last client disconnects.
+type PresenceData struct {
+	UserID string `json:"user_id,omitempty"`
+	Status Status `json:"status"`
+}
+
+// Message Type: JoinRoom, LeaveRoom
+// Direction: Bidirectional
+// Purpose: Inbound messages join or leave RoomID on the senders connection. Outbound messages confirm the change,
+// a join is confirmed before the rooms recent history is sent. Reason is set when a moderator removed the client.
+type RoomMembershipData struct {
+	RoomID            string `json:"room_id"`
+	Reason            string `json:"reason,omitempty"`
+	LastSeenMessageID string `json:"last_seen_message_id,omitempty"` // inbound only, replays the messages missed since
+}
+
+// Message Type: Resume
+// Direction: Outbound
+// Purpose: Sent instead of the recent history when a client connects with the last_seen_message_id query param or
+// joins a room with LastSeenMessageID, followed by the Replayed messages sent in the room since, oldest first.
+// Complete is false when too many messages were missed or the message is unknown, the messages that follow are then
+// the rooms recent history and replace what the client has.
+type ResumeData struct {
+	RoomID            string `json:"room_id"`
+	LastSeenMessageID string `json:"last_seen_message_id"`
+	Replayed          int    `json:"replayed"`
+	Complete          bool   `json:"complete"`
+}
+
+// Message Type: Hello
+// Direction: Inbound
+// Purpose: Sent by the client once it connects. Versions the server doesn't speak get an unsupported_version error
+// frame and the connection is closed. Features lists the optional frames the client wants, see protocol.go,
+// clients that never send a hello get all of them, and a welcome before their first other message is handled.
+type HelloData struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Features        []string `json:"features,omitempty"`
+	Client          string   `json:"client,omitempty"` // name and version of the client, e.g. lobby/1.0, only for logging
+}
+
+// Message Type: Welcome
+// Direction: Outbound
+// Purpose: Reply to hello with the user the connection authenticated as, the limits it is held to and the features
+// that were enabled, also sent before the first message of a client that didn't say hello. Messages over the limits
+// are rejected with an error frame rather than closing the connection.
+type WelcomeData struct {
+	ProtocolVersion int        `json:"protocol_version"` // the version from the hello, MinProtocolVersion without one
+	ServerVersion   string     `json:"server_version"`
+	SessionID       string     `json:"session_id"` // this connection, a user can have several
+	UserID          string     `json:"user_id"`
+	Username        string     `json:"username"`
+	Limits          LimitsData `json:"limits"`
+	Features        []string   `json:"features"`
+}
+
+// limits on what a client can send
+type LimitsData struct {
+	MaxFrameBytes int                           `json:"max_frame_bytes"` // largest inbound WebSocketMessage in bytes
+	MaxTextRunes  int                           `json:"max_text_runes"`  // longest chat, direct or edited message text in characters
+	MaxRooms      int                           `json:"max_rooms"`       // most rooms one connection can be in
+	RateLimits    map[MessageType]RateLimitData `json:"rate_limits"`     // types not listed share a default limit
+}
+
+// token bucket limit on a message type, Burst messages can be sent at once and PerSecond more every second after
+type RateLimitData struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+// Message Type: UsernameUpdate
+// Direction: Inbound
+// Purpose:
+type UsernameUpdateData struct {
+	Username string `json:"username"`
+}
+
+// Message Type: UserList
+// Direction: Outbound
+// Purpose: The payload inside a WebSocketMessage to update currently active users
+type UserListMessage struct {
+	RoomID string     `json:"room_id"`
+	Users  []UserItem `json:"users"`
+}
+
+// A single entry to represent a connected client
+type UserItem struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Status   Status `json:"status"`
+}
@@ -0,0 +1,42 @@
+// Package protocol holds the websocket wire format shared by the chat server and the Go client in pkg/client.
+// It has no dependencies outside the standard library, so clients can import it without pulling in the server.
+package protocol
+
+import "slices"
+
+// versions of the websocket protocol in models.go, bumped when a change would break existing clients
+// clients that never send a hello are treated as speaking MinProtocolVersion
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// websocket subprotocols a client can ask for in Sec-WebSocket-Protocol, JSON text frames are used without one
+const (
+	JSONSubprotocol    = "chatapp.json"
+	MsgpackSubprotocol = "chatapp.msgpack" // MessagePack binary frames with the same fields as the JSON protocol
+)
+
+// close codes the server uses on top of the standard ones, clients reconnect and resume after CloseSlowConsumer
+const (
+	CloseSlowConsumer       = 4008 // the client didn't read its messages fast enough
+	CloseUnsupportedVersion = 4009 // the clients hello asked for a protocol version the server doesn't speak
+)
+
+// optional outbound frames a client can ask for in its hello, clients that don't send one get all of them
+var FeatureTypes = map[string]MessageType{
+	"typing":        Typing,
+	"presence":      Presence,
+	"read_receipts": ReadReceipt,
+	"reactions":     ReactionUpdate,
+}
+
+// names of the optional features, sorted
+func Features() []string {
+	names := make([]string, 0, len(FeatureTypes))
+	for feature := range FeatureTypes {
+		names = append(names, feature)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,11 @@
+package protocol
+
+// a users presence across all of their connected clients
+type Status string
+
+const (
+	Online       Status = "online"  // connected and active recently
+	Away         Status = "away"    // chosen by the user, or connected but idle for a few minutes
+	DoNotDisturb Status = "dnd"     // chosen by the user
+	Offline      Status = "offline" // no connected clients
+)
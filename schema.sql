@@ -19,14 +19,132 @@ CREATE TABLE refresh_tokens (
     created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 );
 
--- Table for saving messages
+-- Table for chat rooms, rooms without an owner are created by the server
+CREATE TABLE rooms (
+    id TEXT PRIMARY KEY DEFAULT gen_random_uuid()::text,
+    name TEXT NOT NULL,
+    topic TEXT NOT NULL DEFAULT '',
+    owner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    visibility TEXT NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'private')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    archived_at TIMESTAMPTZ -- archived rooms keep their history but can't be joined
+);
+
+-- Default room the lobby joins on login
+INSERT INTO rooms (id, name, topic) VALUES ('1', 'Lobby', 'General chat for everyone');
+
+-- Members of a room and their role, private rooms can only be joined by their members
+CREATE TABLE room_members (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'moderator', 'member')),
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Invitations from a room owner to a user, a user has at most one invite per room
+CREATE TABLE room_invites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    inviter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invitee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMPTZ,
+    UNIQUE (room_id, invitee_id)
+);
+
+-- Users banned from a room, a null expires_at is a permanent ban
+CREATE TABLE room_bans (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    banned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Users who can't send chat messages in a room, a null expires_at lasts until unmuted
+CREATE TABLE room_mutes (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    muted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Table for saving messages, room messages have a room_id and direct messages have a receiver_id
 CREATE TABLE messages (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id TEXT,
     sender_id UUID NOT NULL,
-    receiver_id UUID NOT NULL,
+    receiver_id UUID,
     text TEXT NOT NULL,
-    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMPTZ, -- set once a direct message reaches the receiver, null while queued offline
+    client_msg_id TEXT, -- sender supplied ID used to deduplicate retransmissions
+    edited_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ, -- deleted messages keep their row as a tombstone with empty text
+    parent_message_id UUID, -- set on thread replies to the message that started the thread
+    reply_count INT NOT NULL DEFAULT 0, -- replies in the thread started by this message
+    last_reply_at TIMESTAMPTZ,
 
     CONSTRAINT fk_sender FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE, 
-    CONSTRAINT fk_receiver FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE 
-);
\ No newline at end of file
+    CONSTRAINT fk_receiver FOREIGN KEY (receiver_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_room FOREIGN KEY (room_id) REFERENCES rooms(id) ON DELETE CASCADE,
+    CONSTRAINT fk_parent FOREIGN KEY (parent_message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    CONSTRAINT chk_destination CHECK (room_id IS NOT NULL OR receiver_id IS NOT NULL)
+);
+
+-- Room history is always read newest first within a room
+CREATE INDEX idx_messages_room_created ON messages (room_id, created_at DESC, id DESC);
+
+-- Thread replies are read in order under their parent
+CREATE INDEX idx_messages_parent_created ON messages (parent_message_id, created_at) WHERE parent_message_id IS NOT NULL;
+
+-- Direct messages waiting for an offline receiver to connect
+CREATE INDEX idx_messages_undelivered ON messages (receiver_id, created_at) WHERE receiver_id IS NOT NULL AND delivered_at IS NULL;
+
+-- A retransmitted message with the same client ID from the same sender to the same room or receiver is stored once
+CREATE UNIQUE INDEX idx_messages_client_msg_id ON messages (sender_id, room_id, receiver_id, client_msg_id) NULLS NOT DISTINCT WHERE client_msg_id IS NOT NULL;
+
+-- Previous text of edited and deleted messages
+CREATE TABLE message_edits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    editor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    previous_text TEXT NOT NULL,
+    edited_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_edits_message ON message_edits (message_id, edited_at);
+
+-- Emoji reactions on messages, each user can react with each emoji once per message
+CREATE TABLE message_reactions (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id, emoji)
+);
+
+-- The last message each user has read in each room, used for read receipts and unread counts
+CREATE TABLE room_reads (
+    room_id TEXT NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    read_at TIMESTAMPTZ NOT NULL, -- created_at of message_id, messages after it are unread
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Broker messages too large for a NOTIFY payload, listeners load them by id and old rows are cleaned up on insert
+CREATE TABLE broker_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_broker_payloads_created ON broker_payloads (created_at);